@@ -0,0 +1,37 @@
+package shimgo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/exec"
+)
+
+const (
+	// tokenVariable is the environment variable that passes a server's
+	// token to its service.
+	tokenVariable = "SHIMGO_TOKEN"
+
+	// tokenHeader carries the token on every request to the service,
+	// which rejects requests without it, so that other local
+	// processes can't use the service.
+	tokenHeader = "X-Shimgo-Token"
+)
+
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// passToken adds the token to the command's environment.
+func passToken(cmd *exec.Cmd, token string) {
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+
+	cmd.Env = append(cmd.Env, tokenVariable+"="+token)
+}
@@ -0,0 +1,57 @@
+package shimgo
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestServicesRequireToken(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	// the stdio transport doesn't need flask, so the bundled service
+	// can run here.
+	s := newServer(pythonServer, serverConfig{transport: TransportStdio})
+	defer cleanup(t, s)
+	require(t, len(s.token) == 64, "servers should have a random token:", s.token)
+
+	require(t, s.startIfNeeded(context.Background()) == nil, "the service should accept the server's token", s.getError())
+
+	for _, token := range []string{"", "wrong", s.getToken()[1:] + "0"} {
+		req, err := http.NewRequest("GET", s.getURI("support/rst"), nil)
+		require(t, err == nil, "creating request", err)
+		if token != "" {
+			req.Header.Set(tokenHeader, token)
+		}
+
+		response, err := s.getClient().Do(req)
+		require(t, err == nil, "request should reach the service", err)
+		response.Body.Close()
+		assert(t, response.StatusCode == http.StatusForbidden, "requests without the token should be rejected:", token, response.Status)
+	}
+
+	caps, err := s.capabilities(context.Background(), RST)
+	assert(t, err == nil && caps.Interpreter != "", "requests from the server should be accepted", err)
+
+	previous := s.getToken()
+	s.stop()
+	s.Lock()
+	s.setup()
+	s.Unlock()
+	assert(t, s.getToken() != previous, "each process should get a new token")
+}
+
+func TestPassToken(t *testing.T) {
+	cmd := exec.Command("true")
+	passToken(cmd, "abc")
+	assert(t, len(cmd.Env) == len(os.Environ())+1, "the environment should be inherited")
+	assert(t, cmd.Env[len(cmd.Env)-1] == "SHIMGO_TOKEN=abc", "the token should be passed:", cmd.Env)
+
+	cmd.Env = []string{"A=b"}
+	passToken(cmd, "abc")
+	assert(t, len(cmd.Env) == 2 && cmd.Env[0] == "A=b", "the command's environment should be kept:", cmd.Env)
+}
@@ -2,35 +2,201 @@ package shimgo
 
 import (
 	"errors"
+	"fmt"
 	"os/exec"
-	"path/filepath"
+	"sync"
 )
 
-type backend int
+// BackendSpec describes a conversion service that shimgo can run as a
+// child process. The service must answer the same HTTP routes as the
+// bundled services:
+//
+//   - GET / returns a JSON overview that includes "status": "running"
+//     once the service is ready. It may also include "interpreter",
+//     "interpreter_version" and "formats", which Capabilities reports.
+//   - GET /support/<format> returns a 200 for supported formats, or a
+//     400 with a body that explains why the format is not supported.
+//   - POST /<format> converts the request body, and returns a JSON
+//     object with the "content", and optionally "info" and
+//     "diagnostics". When the request accepts text/html, as those of
+//     ConvertStream do, the service should return the content as the
+//     response body instead, with the info and diagnostics as JSON in
+//     the X-Shimgo-Info and X-Shimgo-Diagnostics headers.
+//   - POST /<format>/document returns the same JSON object with a
+//     "document" that holds the fields of Document.
+//   - POST /<format>/metadata returns a JSON object with the
+//     "metadata", or a 404 if the service cannot extract it.
+//
+// When Options are set, the conversion routes receive them as JSON in
+// the "options" query parameter, and should answer a 400 for an Output
+// that they cannot write. Backends that use TransportStdio receive the
+// same requests as frames on stdin; see TransportStdio.
+//
+// Each process gets a random token in the SHIMGO_TOKEN environment
+// variable, and every request carries it in the X-Shimgo-Token
+// header. Services should reject requests without it.
+type BackendSpec struct {
+	// Files maps file names to their contents. They are written to
+	// the server's working directory before the service starts.
+	Files map[string][]byte
+
+	// Command returns the command that runs the service from the
+	// working directory, listening on the given address: a localhost
+	// port, "unix:" followed by the path of the socket to create when
+	// the backend uses TransportUnix, or "stdio" when it uses
+	// TransportStdio.
+	Command func(workingDirectory, address string) *exec.Cmd
+
+	// Formats lists the formats that the service converts. They are
+	// registered to the backend when it is registered.
+	Formats []Format
+
+	// HealthPath is the route that reports the JSON overview while the
+	// service starts. The default is "/".
+	HealthPath string
+
+	// command is used instead of Command by the bundled services, so
+	// that converters can choose their interpreters.
+	command func(c runtimeConfig, workingDirectory, address string) *exec.Cmd
+}
+
+type backend string
 
 const (
-	pythonServer backend = iota
-	rubyServer
+	pythonServer backend = "python"
+	rubyServer   backend = "ruby"
 )
 
+var registry = &backendRegistry{
+	specs: map[backend]BackendSpec{
+		pythonServer: {
+			Files: map[string][]byte{
+				pythonService: serviceFiles[pythonService],
+			},
+			Command: func(workingDirectory, address string) *exec.Cmd {
+				return pythonCommand(runtimeConfig{}.withEnvironment(), workingDirectory, address)
+			},
+			Formats: []Format{RST, ASCIIDOC},
+			command: pythonCommand,
+		},
+		rubyServer: {
+			Files: map[string][]byte{
+				rubyService: serviceFiles[rubyService],
+			},
+			Command: func(workingDirectory, address string) *exec.Cmd {
+				return rubyCommand(runtimeConfig{}.withEnvironment(), workingDirectory, address)
+			},
+			Formats: []Format{ASCIIDOCTOR},
+			command: rubyCommand,
+		},
+	},
+	formats: map[Format]backend{
+		RST:         pythonServer,
+		ASCIIDOC:    pythonServer,
+		ASCIIDOCTOR: rubyServer,
+	},
+}
+
+type backendRegistry struct {
+	specs   map[backend]BackendSpec
+	formats map[Format]backend
+	mu      sync.RWMutex
+}
+
+// RegisterBackend adds a conversion service, or replaces the service
+// registered with the same name, and routes its formats to it.
+// Converters pick up the change the next time they look up one of
+// the formats; servers that are already running are not restarted.
+func RegisterBackend(name string, spec BackendSpec) error {
+	if name == "" {
+		return errors.New("backend name must not be empty")
+	}
+
+	if spec.Command == nil {
+		return fmt.Errorf("backend '%s' does not define a command", name)
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.specs[backend(name)] = spec
+	for _, f := range spec.Formats {
+		registry.formats[f] = backend(name)
+	}
+
+	return nil
+}
+
+// RegisterFormat routes conversions of the format to a registered
+// backend.
+func RegisterFormat(f Format, backendName string) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.specs[backend(backendName)]; !ok {
+		return fmt.Errorf("backend '%s' is not registered", backendName)
+	}
+
+	registry.formats[f] = backend(backendName)
+
+	return nil
+}
+
+func (r *backendRegistry) backendFor(f Format) (backend, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	b, ok := r.formats[f]
+	return b, ok
+}
+
+func (r *backendRegistry) formatMap() map[Format]backend {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make(map[Format]backend, len(r.formats))
+	for f, b := range r.formats {
+		out[f] = b
+	}
+
+	return out
+}
+
+func (r *backendRegistry) spec(b backend) (BackendSpec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	spec, ok := r.specs[b]
+	return spec, ok
+}
+
 func (b backend) writeFiles(workingDirectory string) error {
-	switch b {
-	case pythonServer:
-		return writeFiles([]string{pythonService, asciidoc, asciidocapi}, workingDirectory)
-	case rubyServer:
-		return writeFiles([]string{rubyService}, workingDirectory)
-	default:
+	spec, ok := registry.spec(b)
+	if !ok {
 		return errors.New("unsupported backend")
 	}
+
+	return writeFiles(spec.Files, workingDirectory)
 }
 
-func (b backend) getCommand(workingDirectory, port string) *exec.Cmd {
-	switch b {
-	case pythonServer:
-		return exec.Command(getPython2(), filepath.Join(workingDirectory, pythonService), port)
-	case rubyServer:
-		return exec.Command(getRuby(), filepath.Join(workingDirectory, rubyService), port)
-	default:
+func (b backend) commandFor(c runtimeConfig, workingDirectory, address string) *exec.Cmd {
+	spec, ok := registry.spec(b)
+	if !ok {
 		return nil
 	}
+
+	if spec.command != nil {
+		return spec.command(c, workingDirectory, address)
+	}
+
+	return spec.Command(workingDirectory, address)
+}
+
+func (b backend) healthPath() string {
+	spec, _ := registry.spec(b)
+	if spec.HealthPath == "" {
+		return "/"
+	}
+
+	return spec.HealthPath
 }
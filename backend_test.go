@@ -0,0 +1,37 @@
+package shimgo
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestRegisterBackend(t *testing.T) {
+	spec := BackendSpec{
+		Files:   map[string][]byte{"service.sh": []byte("exit 0")},
+		Command: func(wd, port string) *exec.Cmd { return exec.Command("sh", "service.sh", port) },
+		Formats: []Format{"test-markup"},
+	}
+	assert(t, RegisterBackend("", spec) != nil, "backends must have a name")
+	assert(t, RegisterBackend("test", BackendSpec{}) != nil, "backends must have a command")
+	assert(t, RegisterFormat("test-other-markup", "test") != nil, "formats can't use unregistered backends")
+
+	c := NewConverter()
+	cleanupConverter(t, c)
+	_, ok := c.servers.lookup("test-markup")
+	assert(t, !ok, "unregistered formats should not have a server")
+
+	registerTestBackend(t, "test", spec)
+	require(t, RegisterFormat("test-other-markup", "test") == nil, "registering a format for a registered backend")
+
+	one, ok := c.servers.lookup("test-markup")
+	require(t, ok, "converters should see backends registered after they were created")
+	assert(t, one.backend == "test", "server should use the registered backend:", one.backend)
+
+	other, ok := c.servers.lookup("test-other-markup")
+	require(t, ok, "converters should see formats registered after they were created")
+	assert(t, one == other, "formats of one backend should share a server")
+
+	cmd := one.backend.commandFor(runtimeConfig{}, one.workingDirectory, "1234")
+	require(t, cmd != nil, "registered backends should provide a command")
+	assert(t, cmd.Args[len(cmd.Args)-1] == "1234", "command should receive the port:", cmd.Args)
+}
@@ -0,0 +1,101 @@
+package shimgo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// BackendCapabilities describes the toolchain that converts a format, as
+// reported by its backend.
+type BackendCapabilities struct {
+	Format  Format
+	Backend string
+
+	// Interpreter and InterpreterVersion identify the runtime of the
+	// backend, e.g. "CPython" and "3.11.7".
+	Interpreter        string
+	InterpreterVersion string
+
+	// Versions maps the libraries that convert the format to their
+	// versions, e.g. "docutils" or "asciidoctor".
+	Versions map[string]string
+
+	// Writers lists the output formats that ConvertTo accepts.
+	Writers []OutputFormat
+
+	// Extensions lists the extensions that the backend loaded.
+	Extensions []string
+
+	// Options lists the fields of Options that the backend uses, by
+	// their JSON names.
+	Options []string
+}
+
+type overview struct {
+	Interpreter        string `json:"interpreter"`
+	InterpreterVersion string `json:"interpreter_version"`
+	Formats            map[Format]struct {
+		Versions   map[string]string `json:"versions"`
+		Writers    []OutputFormat    `json:"writers"`
+		Extensions []string          `json:"extensions"`
+		Options    []string          `json:"options"`
+	} `json:"formats"`
+}
+
+// Capabilities starts the backend for the format, if needed, and
+// reports the toolchain that it uses.
+func (c *Converter) Capabilities(f Format) (*BackendCapabilities, error) {
+	return c.CapabilitiesContext(context.Background(), f)
+}
+
+// CapabilitiesContext is Capabilities with a context, which is
+// handled as in ConvertContext.
+func (c *Converter) CapabilitiesContext(ctx context.Context, f Format) (*BackendCapabilities, error) {
+	var caps *BackendCapabilities
+	err := c.convert(ctx, f, func(server *shimServer) (err error) {
+		caps, err = server.capabilities(ctx, f)
+		return err
+	})
+
+	return caps, err
+}
+
+func (s *shimServer) capabilities(ctx context.Context, format Format) (*BackendCapabilities, error) {
+	req, err := http.NewRequest("GET", s.getURI(""), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(tokenHeader, s.getToken())
+
+	response, err := s.getClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, newRequestError(ctx, format, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return nil, newStatusError(format, response)
+	}
+
+	out := overview{}
+	if err := json.NewDecoder(response.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+
+	caps := &BackendCapabilities{
+		Format:             format,
+		Backend:            string(s.backend),
+		Interpreter:        out.Interpreter,
+		InterpreterVersion: out.InterpreterVersion,
+	}
+
+	if f, ok := out.Formats[format]; ok {
+		caps.Versions = f.Versions
+		caps.Writers = f.Writers
+		caps.Extensions = f.Extensions
+		caps.Options = f.Options
+	}
+
+	return caps, nil
+}
@@ -0,0 +1,31 @@
+package shimgo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	s := newFakeServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert(t, r.URL.Path == "/", "capabilities should come from the overview:", r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": "running", "interpreter": "CPython", "interpreter_version": "3.11.7",
+			"formats": {"rst": {"versions": {"docutils": "0.20.1"}, "writers": ["html", "latex"],
+			"extensions": [], "options": ["settings_overrides", "output"]}}}`))
+	}))
+
+	caps, err := s.capabilities(context.Background(), RST)
+	require(t, err == nil, "reading capabilities", err)
+	assert(t, caps.Format == RST && caps.Backend == string(pythonServer), "capabilities should name the format and backend:", caps)
+	assert(t, caps.Interpreter == "CPython" && caps.InterpreterVersion == "3.11.7", "interpreter should be reported:", caps)
+	assert(t, caps.Versions["docutils"] == "0.20.1", "library versions should be reported:", caps.Versions)
+	assert(t, len(caps.Writers) == 2 && caps.Writers[1] == LaTeX, "writers should be reported:", caps.Writers)
+	assert(t, len(caps.Options) == 2, "options should be reported:", caps.Options)
+
+	caps, err = s.capabilities(context.Background(), ASCIIDOC)
+	require(t, err == nil, "reading capabilities", err)
+	assert(t, caps.Interpreter == "CPython", "interpreter should be reported for every format")
+	assert(t, caps.Versions == nil && caps.Writers == nil, "formats the backend does not describe have no details")
+}
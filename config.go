@@ -0,0 +1,121 @@
+package shimgo
+
+import (
+	"runtime"
+	"time"
+)
+
+// StartupPolicy controls how a server waits for its backend process to
+// become ready, and how it retries starting the process. Zero fields
+// use the defaults.
+type StartupPolicy struct {
+	// Timeout is how long a process has to report that it is running
+	// before the attempt fails. The default is 10s.
+	Timeout time.Duration
+
+	// PollInterval is the delay between checks of a starting
+	// process's health endpoint. The default is 100ms.
+	PollInterval time.Duration
+
+	// Attempts is how many processes are started, one after another,
+	// before the server gives up. The default is 3.
+	Attempts int
+
+	// Backoff is the delay before the second attempt. It doubles with
+	// each attempt after that. The default is 100ms.
+	Backoff time.Duration
+
+	// Cooldown is how long a server that gave up waits before a
+	// conversion makes it try again. The default is 10s.
+	Cooldown time.Duration
+}
+
+func (p StartupPolicy) withDefaults() StartupPolicy {
+	if p.Timeout <= 0 {
+		p.Timeout = 10 * time.Second
+	}
+
+	if p.PollInterval <= 0 {
+		p.PollInterval = 100 * time.Millisecond
+	}
+
+	if p.Attempts <= 0 {
+		p.Attempts = 3
+	}
+
+	if p.Backoff <= 0 {
+		p.Backoff = 100 * time.Millisecond
+	}
+
+	if p.Cooldown <= 0 {
+		p.Cooldown = 10 * time.Second
+	}
+
+	return p
+}
+
+// serverConfig holds the settings that a Converter passes to each of
+// its servers.
+type serverConfig struct {
+	tempDir    string
+	transport  Transport
+	transports map[backend]Transport
+	workers    int
+	poolSizes  map[backend]int
+	startup    StartupPolicy
+	policies   map[backend]StartupPolicy
+	grace      time.Duration
+	runtime    runtimeConfig
+	idle       time.Duration
+	idleTimes  map[backend]time.Duration
+}
+
+// forBackend resolves the settings that depend on the backend.
+func (c serverConfig) forBackend(b backend) serverConfig {
+	if t, ok := c.transports[b]; ok {
+		c.transport = t
+	}
+
+	if c.transport == "" {
+		c.transport = TransportTCP
+	}
+
+	if n, ok := c.poolSizes[b]; ok {
+		c.workers = n
+	}
+
+	if c.workers <= 0 {
+		c.workers = runtime.GOMAXPROCS(0)
+	}
+
+	if p, ok := c.policies[b]; ok {
+		c.startup = p
+	}
+
+	c.startup = c.startup.withDefaults()
+
+	if c.grace <= 0 {
+		c.grace = defaultGracePeriod
+	}
+
+	c.runtime = c.runtime.withEnvironment()
+
+	if d, ok := c.idleTimes[b]; ok {
+		c.idle = d
+	}
+
+	return c
+}
+
+// idleCheckInterval returns how often servers look for idle backends,
+// or zero if no backend has an idle timeout.
+func (c serverConfig) idleCheckInterval() time.Duration {
+	shortest := c.idle
+	for _, d := range c.idleTimes {
+		if d > 0 && (shortest <= 0 || d < shortest) {
+			shortest = d
+		}
+	}
+
+	return shortest / 2
+}
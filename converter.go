@@ -0,0 +1,303 @@
+package shimgo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Converter owns a set of backend services and converts documents
+// using them. Each Converter starts and manages its own backend
+// processes, so several differently configured converters can run in
+// the same process without interfering with each other.
+type Converter struct {
+	servers *servers
+	conf    serverConfig
+	priming map[Format][]byte
+}
+
+// ConverterOption configures a Converter during construction.
+type ConverterOption func(*Converter)
+
+// WithTempDir sets the directory in which the converter creates the
+// working directories for its backend services. The default is the
+// system temporary directory.
+func WithTempDir(path string) ConverterOption {
+	return func(c *Converter) { c.conf.tempDir = path }
+}
+
+// WithTransport sets how the converter communicates with its backend
+// services. The default is TransportTCP.
+func WithTransport(t Transport) ConverterOption {
+	return func(c *Converter) { c.conf.transport = t }
+}
+
+// WithBackendTransport sets the transport for one backend, overriding
+// the transport set with WithTransport.
+func WithBackendTransport(name string, t Transport) ConverterOption {
+	return func(c *Converter) {
+		if c.conf.transports == nil {
+			c.conf.transports = map[backend]Transport{}
+		}
+		c.conf.transports[backend(name)] = t
+	}
+}
+
+// WithWorkers sets how many processes the converter runs for each
+// backend. Processes are started as concurrent conversions need them.
+// The default is runtime.GOMAXPROCS(0).
+func WithWorkers(n int) ConverterOption {
+	return func(c *Converter) { c.conf.workers = n }
+}
+
+// WithBackendWorkers sets how many processes the converter runs for
+// one backend, overriding the number set with WithWorkers.
+func WithBackendWorkers(name string, n int) ConverterOption {
+	return func(c *Converter) {
+		if c.conf.poolSizes == nil {
+			c.conf.poolSizes = map[backend]int{}
+		}
+		c.conf.poolSizes[backend(name)] = n
+	}
+}
+
+// WithStartupPolicy sets how the converter's servers wait for their
+// backend processes to start, and retry starting them.
+func WithStartupPolicy(p StartupPolicy) ConverterOption {
+	return func(c *Converter) { c.conf.startup = p }
+}
+
+// WithBackendStartupPolicy sets the startup policy for one backend,
+// overriding the policy set with WithStartupPolicy, e.g. to give a
+// JRuby backend longer to start.
+func WithBackendStartupPolicy(name string, p StartupPolicy) ConverterOption {
+	return func(c *Converter) {
+		if c.conf.policies == nil {
+			c.conf.policies = map[backend]StartupPolicy{}
+		}
+		c.conf.policies[backend(name)] = p
+	}
+}
+
+// WithGracePeriod sets how long backend processes have to exit after
+// they are asked to stop, before they are killed. The default is two
+// seconds.
+func WithGracePeriod(d time.Duration) ConverterOption {
+	return func(c *Converter) { c.conf.grace = d }
+}
+
+// WithPython sets the Python interpreter that runs the bundled Python
+// service, overriding SHIMGO_PYTHON and SHIMGO_VIRTUALENV. The default
+// is the first of python3, python2 and python on the PATH.
+func WithPython(path string) ConverterOption {
+	return func(c *Converter) { c.conf.runtime.python = path }
+}
+
+// WithVirtualenv runs the bundled Python service with the interpreter
+// of a virtualenv, overriding SHIMGO_PYTHON and SHIMGO_VIRTUALENV. An
+// interpreter set with WithPython takes precedence.
+func WithVirtualenv(dir string) ConverterOption {
+	return func(c *Converter) { c.conf.runtime.virtualenv = dir }
+}
+
+// WithRuby sets the Ruby interpreter that runs the bundled Ruby
+// service, overriding SHIMGO_RUBY. The default is the first of ruby
+// and jruby on the PATH.
+func WithRuby(path string) ConverterOption {
+	return func(c *Converter) { c.conf.runtime.ruby = path }
+}
+
+// WithGemfile runs the bundled Ruby service with bundle exec and the
+// Gemfile, overriding SHIMGO_GEMFILE.
+func WithGemfile(path string) ConverterOption {
+	return func(c *Converter) { c.conf.runtime.gemfile = path }
+}
+
+// WithIdleTimeout stops backend processes that have not converted a
+// document for the duration. They start again on the next conversion.
+// The default is to keep them running until Cleanup.
+func WithIdleTimeout(d time.Duration) ConverterOption {
+	return func(c *Converter) { c.conf.idle = d }
+}
+
+// WithBackendIdleTimeout sets the idle timeout for one backend,
+// overriding the timeout set with WithIdleTimeout. A zero duration
+// keeps the backend's processes running.
+func WithBackendIdleTimeout(name string, d time.Duration) ConverterOption {
+	return func(c *Converter) {
+		if c.conf.idleTimes == nil {
+			c.conf.idleTimes = map[backend]time.Duration{}
+		}
+		c.conf.idleTimes[backend(name)] = d
+	}
+}
+
+// NewConverter builds a Converter. Backend services are not started
+// until they are first needed.
+func NewConverter(opts ...ConverterOption) *Converter {
+	c := &Converter{}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.servers = newServers(c.conf)
+
+	return c
+}
+
+// Cleanup stops all running backend services. Converters with an idle
+// timeout must be cleaned up to stop watching for idle backends.
+func (c *Converter) Cleanup() { c.servers.cleanup() }
+
+// Reset stops all backend services, clears their errors, and restarts
+// those that were running.
+func (c *Converter) Reset() { c.servers.reset() }
+
+// Restarts reports how many times the backend processes for the
+// format were restarted after exiting unexpectedly.
+func (c *Converter) Restarts(f Format) int { return c.servers.restarts(f) }
+
+// Supports reports whether a backend for the format is available.
+func (c *Converter) Supports(f Format) bool { return c.servers.hasSupport(f) }
+
+// Convert renders the content in the given format as HTML.
+func (c *Converter) Convert(f Format, content []byte) ([]byte, error) {
+	return c.ConvertContext(context.Background(), f, content)
+}
+
+// ConvertContext renders the content in the given format as HTML,
+// abandoning the request when the context is canceled. If the
+// context's deadline expires while the backend is working, the
+// backend process is killed and restarted on the next conversion. A
+// backend that is starting when the context is done keeps starting.
+func (c *Converter) ConvertContext(ctx context.Context, f Format, content []byte) ([]byte, error) {
+	return c.ConvertWithOptionsContext(ctx, f, content, Options{})
+}
+
+// ConvertWithOptions renders the content in the given format as HTML
+// using the options rather than the backend's defaults.
+func (c *Converter) ConvertWithOptions(f Format, content []byte, opts Options) ([]byte, error) {
+	return c.ConvertWithOptionsContext(context.Background(), f, content, opts)
+}
+
+// ConvertWithOptionsContext is ConvertWithOptions with a context, which
+// is handled as in ConvertContext.
+func (c *Converter) ConvertWithOptionsContext(ctx context.Context, f Format, content []byte, opts Options) ([]byte, error) {
+	var out []byte
+	err := c.convert(ctx, f, func(server *shimServer) error {
+		result, err := server.doConversion(ctx, f, content, opts)
+		if err != nil {
+			return err
+		}
+
+		out = []byte(result.Content)
+		return result.warning(f)
+	})
+
+	return out, err
+}
+
+// ConvertTo renders the content in the given format using the writer
+// for the output format.
+func (c *Converter) ConvertTo(f Format, output OutputFormat, content []byte) ([]byte, error) {
+	return c.ConvertToContext(context.Background(), f, output, content)
+}
+
+// ConvertToContext is ConvertTo with a context, which is handled as in
+// ConvertContext.
+func (c *Converter) ConvertToContext(ctx context.Context, f Format, output OutputFormat, content []byte) ([]byte, error) {
+	return c.ConvertWithOptionsContext(ctx, f, content, Options{Output: output})
+}
+
+// ConvertDocument renders the content in the given format and returns
+// the parts of the rendered document, so that callers can use the
+// title and metadata separately from the body. Formats whose backend
+// cannot find the parts, such as AsciiDoc, return
+// ErrUnsupportedFormat.
+func (c *Converter) ConvertDocument(f Format, content []byte) (*Document, error) {
+	return c.ConvertDocumentContext(context.Background(), f, content)
+}
+
+// ConvertDocumentContext is ConvertDocument with a context, which is
+// handled as in ConvertContext.
+func (c *Converter) ConvertDocumentContext(ctx context.Context, f Format, content []byte) (*Document, error) {
+	var doc *Document
+	err := c.convert(ctx, f, func(server *shimServer) error {
+		result, err := server.doDocument(ctx, f, content, Options{})
+		if err != nil {
+			return err
+		}
+
+		doc = result.Document
+		return result.warning(f)
+	})
+
+	return doc, err
+}
+
+// ExtractMetadata returns the metadata from the document's header,
+// i.e. the rst docinfo fields or the AsciiDoc header attributes,
+// without rendering the document.
+func (c *Converter) ExtractMetadata(f Format, content []byte) (map[string]string, error) {
+	return c.ExtractMetadataContext(context.Background(), f, content)
+}
+
+// ExtractMetadataContext is ExtractMetadata with a context, which is
+// handled as in ConvertContext.
+func (c *Converter) ExtractMetadataContext(ctx context.Context, f Format, content []byte) (map[string]string, error) {
+	var metadata map[string]string
+	err := c.convert(ctx, f, func(server *shimServer) (err error) {
+		metadata, err = server.doMetadata(ctx, f, content)
+		return err
+	})
+
+	return metadata, err
+}
+
+// ConvertWithDiagnostics renders the content in the given format as
+// HTML and returns the warnings that the backend reported as
+// structured diagnostics. Unlike Convert, the error is only non-nil
+// when the conversion failed.
+func (c *Converter) ConvertWithDiagnostics(f Format, content []byte) ([]byte, []Diagnostic, error) {
+	return c.ConvertWithDiagnosticsContext(context.Background(), f, content)
+}
+
+// ConvertWithDiagnosticsContext is ConvertWithDiagnostics with a
+// context, which is handled as in ConvertContext.
+func (c *Converter) ConvertWithDiagnosticsContext(ctx context.Context, f Format, content []byte) ([]byte, []Diagnostic, error) {
+	var result *conversionResult
+	err := c.convert(ctx, f, func(server *shimServer) (err error) {
+		result, err = server.doConversion(ctx, f, content, Options{})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []byte(result.Content), result.Diagnostics, nil
+}
+
+// ConvertStream renders the input in the given format as HTML and
+// writes the result to output, without holding either document in
+// memory on the Go side. Converters that use TransportStdio, and
+// backends that return JSON rather than the HTML body, exchange whole
+// documents, which are held in memory.
+func (c *Converter) ConvertStream(ctx context.Context, f Format, input io.Reader, output io.Writer) error {
+	return c.convert(ctx, f, func(server *shimServer) error {
+		return server.doStream(ctx, f, input, output)
+	})
+}
+
+// convert runs fn with a worker for the format, and releases the
+// worker when fn returns.
+func (c *Converter) convert(ctx context.Context, f Format, fn func(*shimServer) error) error {
+	server, err := c.servers.getServer(ctx, f)
+	if err != nil {
+		return fmt.Errorf("no suitable backend for '%s' was found: %w", f, err)
+	}
+	defer server.release()
+
+	return fn(server)
+}
@@ -0,0 +1,169 @@
+package shimgo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConvertersAreIndependent(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "shimgo-test-")
+	require(t, err == nil, "creating temporary directory", err)
+	defer os.RemoveAll(tmpdir)
+
+	one := NewConverter(WithTempDir(tmpdir))
+	two := NewConverter()
+
+	require(t, len(one.servers.backends) == len(two.servers.backends), "converters should have the same formats")
+	for format, s := range one.servers.backends {
+		t.Run(fmt.Sprint(format), func(t *testing.T) {
+			other := two.servers.backends[format]
+			require(t, other != nil, "both converters should have a server for", format)
+			assert(t, s != other, "converters should not share server instances")
+			assert(t, s != defaultConverter.servers.backends[format], "converters should not share the default instance")
+			assert(t, s.workingDirectory != other.workingDirectory, "converters should not share working directories")
+			assert(t, filepath.Dir(s.workingDirectory) == tmpdir, "working directory should be in the configured location:", s.workingDirectory)
+
+			cleanup(t, s)
+			cleanup(t, other)
+		})
+	}
+}
+
+func TestConvertersRemoveWorkingDirectories(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "shimgo-test-")
+	require(t, err == nil, "creating temporary directory", err)
+	defer os.RemoveAll(tmpdir)
+
+	for i := 0; i < 3; i++ {
+		c := NewConverter(WithTempDir(tmpdir))
+		c.Reset()
+		c.Cleanup()
+	}
+
+	entries, err := ioutil.ReadDir(tmpdir)
+	require(t, err == nil, "reading temporary directory", err)
+	assert(t, len(entries) == 0, "converters should remove their working directories:", len(entries))
+}
+
+func TestAsciiDocConversion(t *testing.T) {
+	// the stdio transport doesn't need flask, so that only asciidoc
+	// has to be installed.
+	c := NewConverter(WithTransport(TransportStdio))
+	cleanupConverter(t, c)
+
+	if !c.Supports(ASCIIDOC) {
+		t.Skip("asciidoc is not available")
+	}
+
+	out, err := c.Convert(ASCIIDOC, []byte("Hello *world*\n"))
+	require(t, err == nil, "conversion should succeed", err)
+	assert(t, strings.Contains(string(out), "<strong>world</strong>"), "output should be rendered:", string(out))
+
+	out, err = c.ConvertTo(ASCIIDOC, DocBook, []byte("Hello *world*\n"))
+	require(t, err == nil, "conversion to docbook should succeed", err)
+	assert(t, strings.Contains(string(out), "<emphasis role=\"strong\">world</emphasis>"), "output should be docbook:", string(out))
+}
+
+func TestConversionsUseTheContext(t *testing.T) {
+	registerFakeBackend(t, "test-context", "test-context-markup")
+
+	c := NewConverter()
+	cleanupConverter(t, c)
+
+	out, err := c.ConvertWithOptionsContext(context.Background(), "test-context-markup", []byte("text"), Options{})
+	require(t, err == nil, "conversion should succeed", err)
+	assert(t, string(out) == "TEXT", "output should be converted:", string(out))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for name, convert := range map[string]func() error{
+		"options": func() error {
+			_, err := c.ConvertWithOptionsContext(ctx, "test-context-markup", []byte("text"), Options{})
+			return err
+		},
+		"to": func() error {
+			_, err := c.ConvertToContext(ctx, "test-context-markup", HTML, []byte("text"))
+			return err
+		},
+		"document": func() error {
+			_, err := c.ConvertDocumentContext(ctx, "test-context-markup", []byte("text"))
+			return err
+		},
+		"metadata": func() error {
+			_, err := c.ExtractMetadataContext(ctx, "test-context-markup", []byte("text"))
+			return err
+		},
+		"diagnostics": func() error {
+			_, _, err := c.ConvertWithDiagnosticsContext(ctx, "test-context-markup", []byte("text"))
+			return err
+		},
+		"capabilities": func() error {
+			_, err := c.CapabilitiesContext(ctx, "test-context-markup")
+			return err
+		},
+	} {
+		err := convert()
+		assert(t, errors.Is(err, context.Canceled), name, "should stop when the context is canceled:", err)
+	}
+}
+
+func TestStreamingFromJSONBackends(t *testing.T) {
+	registerFakeBackend(t, "test-json", "test-json-markup")
+
+	c := NewConverter()
+	cleanupConverter(t, c)
+
+	output := &bytes.Buffer{}
+	err := c.ConvertStream(context.Background(), "test-json-markup", strings.NewReader("text"), output)
+	require(t, err == nil, "conversion should succeed", err)
+	assert(t, output.String() == "TEXT", "the content of JSON responses should be written:", output.String())
+}
+
+func TestHungBackendsRestart(t *testing.T) {
+	registerFakeBackend(t, "test-hung", "test-hung-markup")
+
+	c := NewConverter()
+	cleanupConverter(t, c)
+
+	_, err := c.Convert("test-hung-markup", []byte("one"))
+	require(t, err == nil, "conversion should succeed", err)
+	server, _ := c.servers.lookup("test-hung-markup")
+	pid := server.getPid()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err = c.ConvertContext(ctx, "test-hung-markup", []byte("sleep"))
+	assert(t, errors.Is(err, context.DeadlineExceeded), "hung conversions should fail at the deadline:", err)
+	assert(t, time.Since(started) < 5*time.Second, "hung conversions should not wait for the backend:", time.Since(started))
+
+	out, err := c.Convert("test-hung-markup", []byte("two"))
+	require(t, err == nil, "the next conversion should succeed", err)
+	assert(t, string(out) == "TWO", "output should be converted:", string(out))
+	assert(t, server.getPid() != pid, "the hung process should have been replaced")
+}
+
+func TestDeadlinesBoundStartup(t *testing.T) {
+	registerSlowBackend(t, "test-slow-start", 3*time.Second, "test-slow-start-markup")
+
+	c := NewConverter()
+	cleanupConverter(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := c.ConvertContext(ctx, "test-slow-start-markup", []byte("one"))
+	assert(t, errors.Is(err, context.DeadlineExceeded), "conversions should fail at the deadline:", err)
+	assert(t, time.Since(started) < time.Second, "conversions should not wait for the backend to start:", time.Since(started))
+}
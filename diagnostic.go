@@ -0,0 +1,39 @@
+package shimgo
+
+import (
+	"fmt"
+)
+
+type Severity string
+
+const (
+	SeverityDebug   Severity = "debug"
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+	SeveritySevere  Severity = "severe"
+)
+
+// Diagnostic describes a problem that a backend reported while
+// converting a document. Line and Column are 1-based, and zero when
+// the backend did not report a position. Source names the tool that
+// produced the message (e.g. docutils or asciidoctor).
+type Diagnostic struct {
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Source   string   `json:"source"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s: %s", d.Source, d.Severity, d.Message)
+	}
+
+	if d.Column == 0 {
+		return fmt.Sprintf("%s: %s: line %d: %s", d.Source, d.Severity, d.Line, d.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: line %d, column %d: %s", d.Source, d.Severity, d.Line, d.Column, d.Message)
+}
@@ -0,0 +1,17 @@
+package shimgo
+
+// Document holds the parts of a rendered document. All parts are
+// HTML. Body contains the whole rendered document, including the
+// title, while Fragment omits the title, subtitle, and DocInfo. Parts
+// holds every part that the backend produced, keyed by the backend's
+// own names (e.g. the docutils publish_parts keys). TOC holds the
+// document's section tree.
+type Document struct {
+	Title    string            `json:"title"`
+	Subtitle string            `json:"subtitle"`
+	Body     string            `json:"body"`
+	DocInfo  string            `json:"docinfo"`
+	Fragment string            `json:"fragment"`
+	TOC      []Section         `json:"toc"`
+	Parts    map[string]string `json:"parts"`
+}
@@ -0,0 +1,136 @@
+package shimgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrUnsupportedFormat is returned when no registered backend can
+	// convert the requested format.
+	ErrUnsupportedFormat = errors.New("unsupported format")
+
+	// ErrBackendUnavailable is returned when the backend for a format
+	// could not be started, or has been stopped.
+	ErrBackendUnavailable = errors.New("backend unavailable")
+
+	// ErrBackendCrashed is returned when a running backend stopped
+	// accepting connections, typically because its process exited.
+	ErrBackendCrashed = errors.New("backend crashed")
+)
+
+// maxErrorBody limits how much of an error response is kept in a
+// TransportError.
+const maxErrorBody = 64 * 1024
+
+// ConversionWarning is returned along with the converted content when
+// the backend reported problems with the document. The content is
+// still usable.
+type ConversionWarning struct {
+	Format      Format
+	Info        string
+	Diagnostics []Diagnostic
+}
+
+func (w *ConversionWarning) Error() string {
+	if w.Info != "" || len(w.Diagnostics) == 0 {
+		return w.Info
+	}
+
+	msgs := make([]string, 0, len(w.Diagnostics))
+	for _, d := range w.Diagnostics {
+		msgs = append(msgs, d.String())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// TransportError describes a failed exchange with a backend: either
+// the request could not be completed, in which case Err is set, or
+// the backend responded with a non-200 status, in which case the
+// status and the body of the response are set.
+type TransportError struct {
+	Format     Format
+	StatusCode int
+	Status     string
+	Body       string
+	Err        error
+}
+
+func (e *TransportError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("problem communicating with '%s' backend: %s", e.Format, e.Err.Error())
+	}
+
+	if e.Body == "" {
+		return fmt.Sprintf("'%s' backend returned %s", e.Format, e.Status)
+	}
+
+	return fmt.Sprintf("'%s' backend returned %s: %s", e.Format, e.Status, strings.TrimSpace(e.Body))
+}
+
+func (e *TransportError) Unwrap() error { return e.Err }
+
+func newRequestError(ctx context.Context, format Format, err error) *TransportError {
+	if ctx.Err() == nil && isConnectionFailure(err) {
+		err = fmt.Errorf("%w: %w", ErrBackendCrashed, err)
+	}
+
+	return &TransportError{Format: format, Err: err}
+}
+
+// newDecodeError reports a response that could not be decoded. It is
+// only a crash if reading the body failed, since a decoder also fails
+// on a body that is empty or not valid.
+func newDecodeError(ctx context.Context, format Format, body *bodyReader, err error) *TransportError {
+	if body.err != nil {
+		return newRequestError(ctx, format, body.err)
+	}
+
+	return &TransportError{Format: format, Err: fmt.Errorf("invalid response: %w", err)}
+}
+
+// bodyReader records the error that stopped reading a response body,
+// other than its end.
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+
+	return n, err
+}
+
+func newStatusError(format Format, response *http.Response) *TransportError {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+
+	return &TransportError{
+		Format:     format,
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Body:       string(body),
+	}
+}
+
+// isConnectionFailure reports whether sending a request, or reading
+// its response, failed because the backend went away. Errors from
+// decoding a response must not be passed to it, since decoders report
+// io.EOF for an empty body.
+func isConnectionFailure(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,75 @@
+package shimgo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestErrorsAreInspectable(t *testing.T) {
+	t.Run("UnregisteredFormat", func(t *testing.T) {
+		_, err := NewConverter().Convert(Format("markdown"), []byte("text"))
+		assert(t, errors.Is(err, ErrUnsupportedFormat), "unknown formats should be unsupported:", err)
+	})
+	t.Run("StoppedServer", func(t *testing.T) {
+		s := newServer(pythonServer, serverConfig{})
+		s.terminated = true
+
+		_, err := s.doConversion(context.Background(), RST, []byte("text"), Options{})
+		assert(t, errors.Is(err, ErrBackendUnavailable), "stopped servers should be unavailable:", err)
+
+		s.terminated = false
+		cleanup(t, s)
+	})
+	t.Run("ErrorStatus", func(t *testing.T) {
+		s := newFakeServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "traceback", http.StatusInternalServerError)
+		}))
+
+		_, err := s.doConversion(context.Background(), RST, []byte("text"), Options{})
+		transportErr := &TransportError{}
+		require(t, errors.As(err, &transportErr), "status errors should be transport errors:", err)
+		assert(t, transportErr.StatusCode == http.StatusInternalServerError, "status should be recorded:", transportErr.StatusCode)
+		assert(t, transportErr.Body == "traceback\n", "body should be recorded:", transportErr.Body)
+		assert(t, !errors.Is(err, ErrBackendCrashed), "status errors are not crashes")
+	})
+	t.Run("UnsupportedOutput", func(t *testing.T) {
+		s := newFakeServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "docbook output is not supported", http.StatusBadRequest)
+		}))
+
+		_, err := s.doConversion(context.Background(), RST, []byte("text"), Options{Output: DocBook})
+		assert(t, errors.Is(err, ErrUnsupportedFormat), "rejected output formats should be unsupported:", err)
+	})
+	t.Run("Crashed", func(t *testing.T) {
+		s := newFakeServer(t, nil)
+
+		_, err := s.doConversion(context.Background(), RST, []byte("text"), Options{})
+		assert(t, errors.Is(err, ErrBackendCrashed), "refused connections should be crashes:", err)
+	})
+	t.Run("InvalidResponse", func(t *testing.T) {
+		s := newFakeServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		_, err := s.doConversion(context.Background(), RST, []byte("text"), Options{})
+		transportErr := &TransportError{}
+		require(t, errors.As(err, &transportErr), "undecodable responses should be transport errors:", err)
+		assert(t, !errors.Is(err, ErrBackendCrashed), "undecodable responses are not crashes:", err)
+	})
+	t.Run("CutShort", func(t *testing.T) {
+		s := newFakeServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte(`{"content": "`))
+		}))
+
+		_, err := s.doConversion(context.Background(), RST, []byte("text"), Options{})
+		assert(t, errors.Is(err, ErrBackendCrashed), "responses cut short should be crashes:", err)
+	})
+	t.Run("Warning", func(t *testing.T) {
+		result := &conversionResult{Diagnostics: []Diagnostic{{Line: 1, Severity: SeverityWarning, Message: "oops", Source: "docutils"}}}
+		warning := &ConversionWarning{}
+		require(t, errors.As(result.warning(RST), &warning), "diagnostics should be reported as a warning")
+		assert(t, warning.Error() == "docutils: warning: line 1: oops", "warning should render diagnostics:", warning)
+		assert(t, (&conversionResult{}).warning(RST) == nil, "results without diagnostics have no warning")
+	})
+}
@@ -0,0 +1,99 @@
+package shimgo
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpreterDiscovery(t *testing.T) {
+	t.Setenv("SHIMGO_PYTHON", "python-from-env")
+	t.Setenv("SHIMGO_GEMFILE", "")
+
+	c := runtimeConfig{ruby: "ruby-from-option"}.withEnvironment()
+	assert(t, c.python == "python-from-env", "environment should configure the interpreter:", c.python)
+	assert(t, c.ruby == "ruby-from-option", "options should override the environment:", c.ruby)
+
+	_, err := getPython(c)
+	require(t, err != nil, "missing interpreters should be reported")
+	assert(t, strings.Contains(err.Error(), "python-from-env"), "error should name the interpreter:", err)
+
+	cmd := pythonCommand(c, "wd", "1234")
+	assert(t, errors.Is(cmd.Start(), exec.ErrNotFound), "commands for missing interpreters should fail to start")
+
+	venv, err := ioutil.TempDir("", "shimgo-venv-")
+	require(t, err == nil, "creating virtualenv", err)
+	defer os.RemoveAll(venv)
+
+	_, err = getPython(runtimeConfig{virtualenv: venv})
+	assert(t, err != nil, "virtualenvs without an interpreter should be reported")
+
+	python := filepath.Join(venv, "bin", "python")
+	require(t, os.MkdirAll(filepath.Dir(python), 0755) == nil, "creating virtualenv bin")
+	require(t, ioutil.WriteFile(python, nil, 0755) == nil, "creating virtualenv python")
+
+	path, err := getPython(runtimeConfig{virtualenv: venv})
+	require(t, err == nil, "virtualenv interpreter should be found", err)
+	assert(t, path == python, "virtualenv interpreter should be used:", path)
+
+	cmd = pythonCommand(runtimeConfig{virtualenv: venv}, "wd", "1234")
+	assert(t, cmd.Args[0] == python, "service should run in the virtualenv:", cmd.Args)
+
+	// SHIMGO_PYTHON is still set, but must not replace the option.
+	c = runtimeConfig{virtualenv: venv}.withEnvironment()
+	assert(t, c.python == "", "environment should not override the virtualenv option:", c.python)
+
+	path, err = getPython(c)
+	require(t, err == nil, "virtualenv interpreter should be found", err)
+	assert(t, path == python, "virtualenv interpreter should be used:", path)
+
+	t.Setenv("SHIMGO_VIRTUALENV", venv)
+	c = runtimeConfig{python: "python-from-option"}.withEnvironment()
+	assert(t, c.virtualenv == "", "environment should not override the python option:", c.virtualenv)
+
+	cmd = pythonCommand(runtimeConfig{python: "sh", virtualenv: venv}, "wd", "1234")
+	for _, v := range cmd.Env {
+		assert(t, !strings.HasPrefix(v, "VIRTUAL_ENV="), "virtualenv should not be exported for another interpreter")
+	}
+}
+
+func TestStartupErrorsIncludeOutput(t *testing.T) {
+	spec := BackendSpec{
+		Command: func(wd, port string) *exec.Cmd {
+			return exec.Command("sh", "-c", "echo 'No module named flask' >&2; exit 1")
+		},
+	}
+	registerTestBackend(t, "test-broken", spec)
+
+	s := newServer("test-broken", serverConfig{startup: StartupPolicy{Attempts: 1}})
+	defer cleanup(t, s)
+
+	err := s.startIfNeeded(context.Background())
+	require(t, err != nil, "broken backends should not start")
+	assert(t, strings.Contains(err.Error(), errExitedDuringStartup.Error()), "error should report the exit:", err)
+	assert(t, strings.Contains(err.Error(), "No module named flask"), "error should include the output:", err)
+}
+
+func TestMissingModulesAreReported(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not available")
+	}
+	if exec.Command("python3", "-c", "import docutils").Run() == nil {
+		t.Skip("docutils is installed")
+	}
+
+	// the stdio transport doesn't need flask, so the bundled service
+	// can run here.
+	c := NewConverter(WithTransport(TransportStdio), WithPython("python3"))
+	cleanupConverter(t, c)
+
+	_, err := c.Convert(RST, []byte("text"))
+	require(t, err != nil, "conversions without docutils should fail")
+	assert(t, errors.Is(err, ErrUnsupportedFormat), "the format should be reported as unsupported:", err)
+	assert(t, strings.Contains(err.Error(), "requires docutils"), "error should name the missing module:", err)
+}
@@ -7,3 +7,19 @@ const (
 	ASCIIDOCTOR        = "asciidoctor"
 	RST                = "rst"
 )
+
+// OutputFormat selects the writer that a backend uses to render a
+// document. Not every backend supports every output format: docutils
+// has no DocBook writer, and the AsciiDoc backends have no plain text
+// or XML writers.
+type OutputFormat string
+
+const (
+	HTML      OutputFormat = "html"
+	DocBook   OutputFormat = "docbook"
+	LaTeX     OutputFormat = "latex"
+	Manpage   OutputFormat = "manpage"
+	XML       OutputFormat = "xml"
+	PseudoXML OutputFormat = "pseudoxml"
+	PlainText OutputFormat = "text"
+)
@@ -0,0 +1,46 @@
+package shimgo
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// Options control how a backend renders a single document. Fields
+// that do not apply to the backend for a format are ignored.
+type Options struct {
+	// SettingsOverrides are passed to docutils as settings_overrides
+	// and replace the defaults for rst conversions.
+	SettingsOverrides map[string]interface{} `json:"settings_overrides,omitempty"`
+
+	// Attributes are document attributes for AsciiDoc and Asciidoctor.
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	// SafeMode is the AsciiDoc safe mode: "unsafe", "safe", "server"
+	// or "secure". The default is "safe".
+	SafeMode string `json:"safe_mode,omitempty"`
+
+	// Doctype is the AsciiDoc doctype, e.g. "article" or "book".
+	Doctype string `json:"doctype,omitempty"`
+
+	// Output selects the writer. The default is HTML. Output other
+	// than HTML is rendered as a standalone document.
+	Output OutputFormat `json:"output,omitempty"`
+}
+
+func (o Options) isZero() bool {
+	return len(o.SettingsOverrides) == 0 && len(o.Attributes) == 0 && o.SafeMode == "" && o.Doctype == "" && o.Output == ""
+}
+
+// query encodes the options for the backend's conversion routes.
+func (o Options) query() (string, error) {
+	if o.isZero() {
+		return "", nil
+	}
+
+	data, err := json.Marshal(o)
+	if err != nil {
+		return "", err
+	}
+
+	return url.Values{"options": []string{string(data)}}.Encode(), nil
+}
@@ -0,0 +1,167 @@
+package shimgo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pool holds the worker processes for one backend. The first worker
+// is created with the pool; the others are added as conversions
+// overlap, up to the configured size.
+type pool struct {
+	backend  backend
+	conf     serverConfig
+	workers  []*shimServer
+	stopping map[*shimServer]chan struct{}
+	mu       sync.Mutex
+}
+
+func newPool(b backend, conf serverConfig) *pool {
+	p := &pool{
+		backend:  b,
+		conf:     conf.forBackend(b),
+		stopping: map[*shimServer]chan struct{}{},
+	}
+	p.workers = []*shimServer{newServer(b, p.conf)}
+
+	return p
+}
+
+// primary returns the first worker, which answers for the pool when
+// no conversion is involved.
+func (p *pool) primary() *shimServer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.workers[0]
+}
+
+// acquire returns the worker with the fewest conversions in progress,
+// adding a worker when they are all busy and the pool is not full.
+// Workers that are stopping, restarting after a crash, or that failed
+// to start and are cooling down, are skipped. If none is usable, the
+// primary is used, so that the caller sees why it failed or waits for
+// it to stop or restart, unless the pool can grow instead. The caller
+// must wait until the worker is stopped, and release it when the
+// conversion is done.
+func (p *pool) acquire() *shimServer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var worker *shimServer
+	for _, w := range p.workers {
+		if p.stopping[w] != nil || w.coolingDown() || w.isRestarting() {
+			continue
+		}
+
+		if worker == nil || w.inFlight() < worker.inFlight() {
+			worker = w
+		}
+	}
+
+	grow := len(p.workers) < p.conf.workers
+	unavailable := p.stopping[p.workers[0]] != nil || p.workers[0].isRestarting()
+	switch {
+	case worker != nil && worker.inFlight() > 0 && grow, worker == nil && unavailable && grow:
+		worker = newServer(p.backend, p.conf)
+		p.workers = append(p.workers, worker)
+	case worker == nil:
+		worker = p.workers[0]
+	}
+
+	atomic.AddInt64(&worker.active, 1)
+
+	return worker
+}
+
+// takeIdle returns the workers that have been idle for at least the
+// idle timeout, including those that are not running, and marks them
+// as stopping so that acquire skips them until they are returned with
+// doneStopping.
+func (p *pool) takeIdle() []*shimServer {
+	if p.conf.idle <= 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idle := []*shimServer{}
+	for _, w := range p.workers {
+		if p.stopping[w] == nil && w.idleFor() >= p.conf.idle {
+			p.stopping[w] = make(chan struct{})
+			idle = append(idle, w)
+		}
+	}
+
+	return idle
+}
+
+func (p *pool) doneStopping(workers []*shimServer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, w := range workers {
+		close(p.stopping[w])
+		delete(p.stopping, w)
+	}
+}
+
+// waitStopped waits until the worker is done stopping, if it is
+// stopping, so that it can be started again.
+func (p *pool) waitStopped(ctx context.Context, w *shimServer) error {
+	p.mu.Lock()
+	stopped := p.stopping[w]
+	p.mu.Unlock()
+
+	if stopped == nil {
+		return nil
+	}
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the backend to stop: %w", ctx.Err())
+	}
+}
+
+// start waits for the worker to stop if it is idle and stopping, and
+// starts it for the format.
+func (p *pool) start(ctx context.Context, server *shimServer, f Format) error {
+	if err := p.waitStopped(ctx, server); err != nil {
+		return err
+	}
+
+	return server.supportsConversion(ctx, f)
+}
+
+func (p *pool) all() []*shimServer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*shimServer{}, p.workers...)
+}
+
+func (s *shimServer) inFlight() int64 { return atomic.LoadInt64(&s.active) }
+
+func (s *shimServer) release() {
+	s.touch()
+	atomic.AddInt64(&s.active, -1)
+}
+
+func (s *shimServer) touch() { atomic.StoreInt64(&s.lastUsed, time.Now().UnixNano()) }
+
+// idleFor reports how long the server has gone without a conversion
+// in progress. It doesn't lock the server, so that it can be checked
+// while the server starts; the caller must check that it is running.
+func (s *shimServer) idleFor() time.Duration {
+	if s.inFlight() > 0 {
+		return 0
+	}
+
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastUsed)))
+}
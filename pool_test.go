@@ -0,0 +1,88 @@
+package shimgo
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	p := newPool(pythonServer, serverConfig{workers: 2})
+	defer func() {
+		for _, s := range p.all() {
+			cleanup(t, s)
+		}
+	}()
+
+	assert(t, len(p.all()) == 1, "pools should start with one worker")
+
+	one := p.acquire()
+	assert(t, one == p.primary(), "idle pools should use the first worker")
+
+	two := p.acquire()
+	assert(t, two != one, "busy pools should add a worker")
+	assert(t, len(p.all()) == 2, "pool should have grown:", len(p.all()))
+
+	three := p.acquire()
+	assert(t, len(p.all()) == 2, "pools should not grow past their size")
+	assert(t, three.inFlight() == 2, "full pools should share the least busy worker")
+
+	one.release()
+	two.release()
+	three.release()
+	assert(t, p.acquire().inFlight() == 1, "released workers should be reused")
+}
+
+func TestWorkerConfiguration(t *testing.T) {
+	conf := serverConfig{poolSizes: map[backend]int{rubyServer: 3}}
+	assert(t, conf.forBackend(pythonServer).workers == runtime.GOMAXPROCS(0), "pools should default to GOMAXPROCS")
+	assert(t, conf.forBackend(rubyServer).workers == 3, "backend pool sizes should apply")
+
+	c := NewConverter(WithWorkers(4), WithBackendWorkers(string(rubyServer), 1))
+	cleanupConverter(t, c)
+	assert(t, c.servers.pools[pythonServer].conf.workers == 4, "converter pool size should apply to backends")
+	assert(t, c.servers.pools[rubyServer].conf.workers == 1, "backend pool size should override the converter's")
+}
+
+func TestUnusableWorkersAreSkipped(t *testing.T) {
+	p := newPool(pythonServer, serverConfig{workers: 2, idle: time.Minute})
+	defer func() {
+		for _, s := range p.all() {
+			cleanup(t, s)
+		}
+	}()
+
+	one := p.acquire()
+	two := p.acquire()
+	require(t, two != one, "busy pools should add a worker")
+
+	two.markFailed()
+	two.release()
+	assert(t, p.acquire() == one, "workers that failed to start should be skipped")
+	assert(t, len(p.all()) == 2, "failed workers should keep their place in the pool")
+	one.release()
+	one.release()
+
+	one.markFailed()
+	assert(t, p.acquire() == one, "the primary should be used when no worker is usable")
+	one.release()
+
+	atomic.StoreInt64(&one.failed, 0)
+	atomic.StoreInt64(&two.failed, 0)
+	one.touch()
+	two.touch()
+	atomic.StoreInt64(&one.lastUsed, time.Now().Add(-time.Hour).UnixNano())
+	idle := p.takeIdle()
+	require(t, len(idle) == 1 && idle[0] == one, "idle workers should be taken:", idle)
+	assert(t, len(p.takeIdle()) == 0, "workers should only be taken once")
+
+	assert(t, p.acquire() == two, "stopping workers should be skipped")
+	assert(t, p.acquire() == two, "stopping workers should be skipped when the others are busy")
+	two.release()
+	two.release()
+
+	p.doneStopping(idle)
+	assert(t, p.acquire() == one, "stopped workers should be used again")
+	one.release()
+}
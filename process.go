@@ -0,0 +1,97 @@
+package shimgo
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxOutputTail bounds how much of a backend's output is kept for
+// error messages.
+const maxOutputTail = 4096
+
+// defaultGracePeriod is how long a backend process has to exit after
+// SIGTERM before it is killed.
+const defaultGracePeriod = 2 * time.Second
+
+// stopProcess asks the process and its children to exit, kills them
+// if they are still running after the grace period, and waits for the
+// process. The exited channel is closed when cmd.Wait returns.
+func stopProcess(cmd *exec.Cmd, exited <-chan struct{}, grace time.Duration) {
+	if terminateProcess(cmd) == nil {
+		select {
+		case <-exited:
+			// the process group may outlive the process.
+			killProcess(cmd)
+			return
+		case <-time.After(grace):
+		}
+	}
+
+	killProcess(cmd)
+	<-exited
+}
+
+// outputTail keeps the end of a process's output.
+type outputTail struct {
+	buf []byte
+	mu  sync.Mutex
+}
+
+func (o *outputTail) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.buf = append(o.buf, p...)
+	if len(o.buf) > maxOutputTail {
+		o.buf = append(o.buf[:0], o.buf[len(o.buf)-maxOutputTail:]...)
+	}
+
+	return len(p), nil
+}
+
+func (o *outputTail) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return strings.TrimSpace(string(o.buf))
+}
+
+// CleanupOnSignal stops the converter's backend services when the
+// program receives SIGINT or SIGTERM, and then lets the signal take
+// its usual effect. The returned function removes the handler.
+func (c *Converter) CleanupOnSignal() (cancel func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			c.Cleanup()
+			signal.Stop(signals)
+			raise(sig)
+		case <-done:
+		}
+	}()
+
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+		})
+	}
+}
+
+func raise(sig os.Signal) {
+	if p, err := os.FindProcess(os.Getpid()); err == nil && p.Signal(sig) == nil {
+		return
+	}
+
+	os.Exit(1)
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package shimgo
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func configureProcess(*exec.Cmd) {}
+
+// terminateProcess fails where processes can't be asked to exit, so
+// that they are killed without waiting for the grace period.
+func terminateProcess(*exec.Cmd) error {
+	return errors.New("graceful termination is not supported")
+}
+
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
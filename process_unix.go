@@ -0,0 +1,25 @@
+//go:build unix
+
+package shimgo
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// configureProcess runs the backend in its own process group, so that
+// it and its children can be signaled together, and asks the kernel
+// to kill it if this process dies without stopping it, where that is
+// supported.
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	setParentDeathSignal(cmd.SysProcAttr)
+}
+
+func terminateProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+func killProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
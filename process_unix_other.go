@@ -0,0 +1,7 @@
+//go:build unix && !linux
+
+package shimgo
+
+import "syscall"
+
+func setParentDeathSignal(*syscall.SysProcAttr) {}
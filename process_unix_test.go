@@ -0,0 +1,82 @@
+//go:build unix
+
+package shimgo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func startProcess(t *testing.T, script string) (*exec.Cmd, chan struct{}, int) {
+	cmd := exec.Command("sh", "-c", script)
+	configureProcess(cmd)
+	stdout, err := cmd.StdoutPipe()
+	require(t, err == nil, "creating pipe", err)
+	require(t, cmd.Start() == nil, "starting process")
+
+	line, _ := bufio.NewReader(stdout).ReadString('\n')
+	child, _ := strconv.Atoi(strings.TrimSpace(line))
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	return cmd, exited, child
+}
+
+// isAlive reports whether the process exists and, where /proc shows
+// it, has not exited without being reaped yet.
+func isAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+func TestStopProcess(t *testing.T) {
+	t.Run("Graceful", func(t *testing.T) {
+		cmd, exited, _ := startProcess(t, "echo; exec sleep 30")
+
+		began := time.Now()
+		stopProcess(cmd, exited, 5*time.Second)
+		assert(t, time.Since(began) < 5*time.Second, "processes should exit on SIGTERM")
+		assert(t, cmd.ProcessState != nil, "process should have been waited on")
+	})
+	t.Run("IgnoresTerm", func(t *testing.T) {
+		cmd, exited, _ := startProcess(t, "trap '' TERM; echo; sleep 30")
+
+		began := time.Now()
+		stopProcess(cmd, exited, 200*time.Millisecond)
+		assert(t, time.Since(began) >= 200*time.Millisecond, "processes should have the grace period to exit")
+		assert(t, cmd.ProcessState != nil, "process should have been killed and waited on")
+	})
+	t.Run("ProcessGroup", func(t *testing.T) {
+		cmd, exited, child := startProcess(t, "trap '' TERM; sleep 30 & echo $!; wait")
+		require(t, child != 0, "reading the pid of the child")
+
+		stopProcess(cmd, exited, 100*time.Millisecond)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) && isAlive(child) {
+			time.Sleep(20 * time.Millisecond)
+		}
+		assert(t, !isAlive(child), "children of the process should be stopped too")
+	})
+}
@@ -1,80 +1,221 @@
 package shimgo
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 )
 
-var serverCache *servers
-
 type servers struct {
 	backends map[Format]*shimServer
+	pools    map[backend]*pool
+	conf     serverConfig
+	done     chan struct{}
+	stopped  sync.Once
 	mu       sync.RWMutex
+
+	// stopMu keeps idle workers from being stopped during reset and
+	// cleanup, without blocking conversions.
+	stopMu sync.Mutex
+}
+
+func newServers(conf serverConfig) *servers {
+	s := &servers{
+		backends: map[Format]*shimServer{},
+		pools:    map[backend]*pool{},
+		conf:     conf,
+		done:     make(chan struct{}),
+	}
+
+	for f, b := range registry.formatMap() {
+		s.backends[f] = s.instance(b)
+	}
+
+	if interval := conf.idleCheckInterval(); interval > 0 {
+		go s.stopIdle(interval)
+	}
+
+	return s
+}
+
+// stopIdle resets the workers that have been idle for longer than the
+// idle timeout of their backend, so that they start again when they
+// are needed. Conversions skip the workers while they stop, and are
+// not blocked by them.
+func (s *servers) stopIdle(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !s.stopIdleWorkers() {
+			return
+		}
+	}
 }
 
-func init() {
-	pyserver := newServer(pythonServer)
-	rbserver := newServer(rubyServer)
+// stopIdleWorkers resets the idle workers of every pool, and returns
+// false if the servers were cleaned up.
+func (s *servers) stopIdleWorkers() bool {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
 
-	serverCache = &servers{
-		backends: map[Format]*shimServer{
-			RST:         pyserver,
-			ASCIIDOC:    pyserver,
-			ASCIIDOCTOR: rbserver,
-		},
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	s.mu.RLock()
+	pools := make([]*pool, 0, len(s.pools))
+	for _, p := range s.pools {
+		pools = append(pools, p)
+	}
+	s.mu.RUnlock()
+
+	for _, p := range pools {
+		idle := p.takeIdle()
+		for _, server := range idle {
+			if server.isRunning() {
+				server.reset()
+			}
+		}
+		p.doneStopping(idle)
+	}
+
+	return true
+}
+
+// instance returns the first worker of the backend's pool, creating
+// the pool if needed. The caller must hold the lock.
+func (s *servers) instance(b backend) *shimServer {
+	p, ok := s.pools[b]
+	if !ok {
+		p = newPool(b, s.conf)
+		s.pools[b] = p
 	}
+
+	return p.primary()
+}
+
+// lookup returns the first worker for the format, following changes
+// to the registered formats since the servers were created.
+func (s *servers) lookup(f Format) (*shimServer, bool) {
+	b, ok := registry.backendFor(f)
+	if !ok {
+		return nil, false
+	}
+
+	s.mu.RLock()
+	server, ok := s.backends[f]
+	s.mu.RUnlock()
+	if ok && server.backend == b {
+		return server, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	server = s.instance(b)
+	s.backends[f] = server
+
+	return server, true
 }
 
 func (s *servers) cleanup() {
+	s.stopped.Do(func() { close(s.done) })
+
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for _, server := range s.backends {
-		server.stop()
+	for _, p := range s.pools {
+		for _, server := range p.all() {
+			server.stop()
+		}
 	}
 }
 
 func (s *servers) reset() {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, server := range s.backends {
-		wasRunning := server.isRunning()
+	for _, p := range s.pools {
+		for _, server := range p.all() {
+			wasRunning := server.isRunning()
 
-		server.reset()
+			server.reset()
 
-		if wasRunning {
-			server.start()
+			if wasRunning {
+				server.start()
+			}
 		}
 	}
 }
 
-func (s *servers) hasSupport(f Format) bool {
+func (s *servers) restarts(f Format) int {
+	primary, ok := s.lookup(f)
+	if !ok {
+		return 0
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	server, ok := s.backends[f]
-	if !ok {
-		return false
+	count := 0
+	for _, server := range s.pools[primary.backend].all() {
+		count += server.getRestarts()
 	}
 
-	if err := server.supportsConversion(f); err != nil {
+	return count
+}
+
+func (s *servers) hasSupport(f Format) bool {
+	server, err := s.getServer(context.Background(), f)
+	if err != nil {
 		return false
 	}
+	server.release()
 
 	return true
 }
 
-func (s *servers) getServer(f Format) (*shimServer, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	server, ok := s.backends[f]
+// getServer picks a worker for the format from the backend's pool,
+// starting it if needed. The caller must release the worker when the
+// conversion is done.
+func (s *servers) getServer(ctx context.Context, f Format) (*shimServer, error) {
+	primary, ok := s.lookup(f)
 	if !ok {
-		return nil, fmt.Errorf("server for '%s' is not registered", f)
+		return nil, fmt.Errorf("%w: server for '%s' is not registered", ErrUnsupportedFormat, f)
+	}
+
+	s.mu.RLock()
+	p := s.pools[primary.backend]
+	s.mu.RUnlock()
+
+	server := p.acquire()
+	err := p.start(ctx, server, f)
+	if err != nil && server != primary && server.coolingDown() {
+		// an added worker failed to start, but the others may work,
+		// and acquire skips the failed one now.
+		server.release()
+		server = p.acquire()
+		err = p.start(ctx, server, f)
 	}
 
-	if err := server.supportsConversion(f); err != nil {
-		return nil, fmt.Errorf("registered server for '%s' does not support conversion [%s]", f, err.Error())
+	if err != nil {
+		server.release()
+		return nil, fmt.Errorf("registered server for '%s' does not support conversion [%w]", f, err)
 	}
 
 	return server, nil
@@ -0,0 +1,141 @@
+package shimgo
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdleBackendsStop(t *testing.T) {
+	registerFakeBackend(t, "test-idle", "test-idle-markup")
+	registerFakeBackend(t, "test-busy", "test-busy-markup")
+
+	c := NewConverter(WithIdleTimeout(100*time.Millisecond), WithBackendIdleTimeout("test-busy", 0))
+	cleanupConverter(t, c)
+
+	for _, f := range []Format{"test-busy-markup", "test-idle-markup"} {
+		_, err := c.Convert(f, []byte("one"))
+		require(t, err == nil, "conversion should succeed", err)
+	}
+
+	idle, _ := c.servers.lookup("test-idle-markup")
+	busy, _ := c.servers.lookup("test-busy-markup")
+	pid := idle.getPid()
+	require(t, pid != 0, "backend should be running")
+
+	// wait until the idle backend is stopped and set up again.
+	p := c.servers.pools["test-idle"]
+	stopped := func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return !idle.isRunning() && len(p.stopping) == 0
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !stopped() {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert(t, !idle.isRunning(), "idle backends should be stopped")
+	assert(t, !idle.hasTerminated(), "idle backends should be able to start again")
+	assert(t, busy.isRunning(), "backends without an idle timeout should keep running")
+
+	out, err := c.Convert("test-idle-markup", []byte("two"))
+	require(t, err == nil, "conversion should restart the backend", err)
+	assert(t, string(out) == "TWO", "restarted backend should convert:", string(out))
+	assert(t, idle.getPid() != pid, "a new process should have been started")
+}
+
+func TestStoppingIdleBackendsDoesNotBlock(t *testing.T) {
+	registerFakeBackend(t, "test-other", "test-other-markup")
+	python, _ := exec.LookPath("python3")
+
+	// the backend ignores SIGTERM, so it stops after the grace period.
+	spec := BackendSpec{
+		Files: map[string][]byte{"fake.py": []byte("import signal\nsignal.signal(signal.SIGTERM, signal.SIG_IGN)\n" + fakeService)},
+		Command: func(wd, port string) *exec.Cmd {
+			return exec.Command(python, filepath.Join(wd, "fake.py"), port)
+		},
+		Formats: []Format{"test-slow-markup"},
+	}
+	registerTestBackend(t, "test-slow", spec)
+
+	c := NewConverter(WithGracePeriod(time.Second), WithBackendIdleTimeout("test-slow", 100*time.Millisecond))
+	cleanupConverter(t, c)
+
+	for _, f := range []Format{"test-other-markup", "test-slow-markup"} {
+		_, err := c.Convert(f, []byte("one"))
+		require(t, err == nil, "conversion should succeed", err)
+	}
+
+	// wait until the idle backend is stopping.
+	p := c.servers.pools["test-slow"]
+	stopping := func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.stopping) > 0
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !stopping() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require(t, stopping(), "idle backend should be stopping")
+
+	started := time.Now()
+	_, err := c.Convert("test-other-markup", []byte("two"))
+	require(t, err == nil, "conversion should succeed", err)
+	assert(t, time.Since(started) < 500*time.Millisecond, "conversions should not wait for idle backends to stop:", time.Since(started))
+}
+
+func TestConversionsWaitForStoppingWorkers(t *testing.T) {
+	registerFakeBackend(t, "test-single", "test-single-markup")
+
+	c := NewConverter(WithWorkers(1), WithIdleTimeout(time.Hour))
+	cleanupConverter(t, c)
+
+	_, err := c.Convert("test-single-markup", []byte("one"))
+	require(t, err == nil, "conversion should succeed", err)
+
+	// stop the only worker as stopIdleWorkers does, and convert
+	// between stopping its process and setting it up again.
+	p := c.servers.pools["test-single"]
+	worker := p.primary()
+	atomic.StoreInt64(&worker.lastUsed, time.Now().Add(-2*time.Hour).UnixNano())
+	idle := p.takeIdle()
+	require(t, len(idle) == 1, "idle worker should be taken:", idle)
+	worker.stop()
+
+	converted := make(chan error, 1)
+	go func() {
+		out, err := c.Convert("test-single-markup", []byte("two"))
+		if err == nil && string(out) != "TWO" {
+			err = fmt.Errorf("unexpected output '%s'", out)
+		}
+		converted <- err
+	}()
+
+	select {
+	case err := <-converted:
+		t.Fatal("conversion should wait for the worker to stop:", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	worker.Lock()
+	worker.setup()
+	worker.Unlock()
+	p.doneStopping(idle)
+
+	err = <-converted
+	require(t, err == nil, "conversion should start the stopped worker", err)
+	assert(t, len(p.all()) == 1, "pool should not grow past its size")
+}
+
+func TestIdleCheckInterval(t *testing.T) {
+	assert(t, serverConfig{}.idleCheckInterval() == 0, "servers without idle timeouts should not check")
+
+	conf := serverConfig{idle: time.Minute, idleTimes: map[backend]time.Duration{rubyServer: 10 * time.Second, pythonServer: 0}}
+	assert(t, conf.idleCheckInterval() == 5*time.Second, "checks should follow the shortest timeout:", conf.idleCheckInterval())
+	assert(t, conf.forBackend(pythonServer).idle == 0, "backends can disable the idle timeout")
+	assert(t, conf.forBackend(rubyServer).idle == 10*time.Second, "backend idle timeouts should apply")
+}
@@ -2,37 +2,66 @@ package shimgo
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// minRestartBackoff and maxRestartBackoff bound the delay before a
+	// crashed backend process is restarted.
+	minRestartBackoff = 100 * time.Millisecond
+	maxRestartBackoff = 30 * time.Second
+
+	// maxErrors bounds how many of the most recent errors a server
+	// keeps.
+	maxErrors = 32
+
+	// maxOverviewSize bounds how much of the overview the readiness
+	// probe reads.
+	maxOverviewSize = 64 * 1024
+)
+
 type shimServer struct {
 	backend          backend
 	supportedFormats []Format
 	running          bool
 	terminated       bool
 	pid              int
-	port             string
+	address          string
 	uri              string
+	client           *http.Client
+	token            string
 	workingDirectory string
+	conf             serverConfig
 	errors           []string
+	failed           int64
+	active           int64
+	lastUsed         int64
+	restarting       bool
+	restarted        chan struct{}
+	restarts         int
+	crashes          int
+	starting         chan struct{}
 	terminate        chan struct{}
 	closed           chan struct{}
 	sync.RWMutex
 }
 
-func newServer(b backend) *shimServer {
+func newServer(b backend, conf serverConfig) *shimServer {
 	server := &shimServer{
 		backend: b,
+		conf:    conf.forBackend(b),
 	}
 	server.setup()
 
@@ -48,102 +77,347 @@ func (s *shimServer) setup() {
 	s.terminated = false
 	s.pid = 0
 	s.errors = []string{}
+	atomic.StoreInt64(&s.failed, 0)
 	s.terminate = make(chan struct{})
 	s.closed = make(chan struct{})
 
-	port, err := findAvailablePort()
+	s.prepare()
+}
+
+// prepare creates a working directory in place of the previous one,
+// and picks an address and a token for the next process. It must be
+// called with exclusive access to the server.
+func (s *shimServer) prepare() {
+	s.removeWorkingDirectory()
+
+	tmpdir, err := ioutil.TempDir(s.conf.tempDir, "shimgo-")
 	if err != nil {
-		s.errors = append(s.errors, err.Error())
+		s.recordError(err.Error())
+	}
+	s.workingDirectory = tmpdir
+
+	if err := s.setupTransport(); err != nil {
+		s.recordError(err.Error())
 	}
-	s.port = strconv.Itoa(port.Port)
-	s.uri = "http://localhost:" + s.port
 
-	tmpdir, err := ioutil.TempDir("", "shimgo-")
+	token, err := newToken()
 	if err != nil {
-		s.errors = append(s.errors, err.Error())
+		s.recordError(err.Error())
+	}
+	s.token = token
+}
+
+// removeWorkingDirectory removes the working directory, which no
+// process may be using. It must be called with exclusive access to
+// the server.
+func (s *shimServer) removeWorkingDirectory() {
+	if s.workingDirectory != "" {
+		os.RemoveAll(s.workingDirectory)
+		s.workingDirectory = ""
 	}
-	s.workingDirectory = tmpdir
 }
 
+// recordError keeps the message among the most recent errors. It
+// must be called with exclusive access to the server.
+func (s *shimServer) recordError(msg string) {
+	s.errors = append(s.errors, msg)
+	if len(s.errors) > maxErrors {
+		s.errors = append(s.errors[:0], s.errors[len(s.errors)-maxErrors:]...)
+	}
+}
+
+// addError records an error that prevents the server from starting
+// until the startup cool-down has passed.
 func (s *shimServer) addError(err error) {
 	if err != nil {
 		s.Lock()
 		defer s.Unlock()
 
-		s.errors = append(s.errors, fmt.Sprintf("%+v", err))
+		s.recordError(fmt.Sprintf("%+v", err))
+		s.markFailed()
 	}
 }
 
-func (s *shimServer) start() {
+// start starts a process for the server, unless one is running or
+// starting already, and waits until it is ready or failed to start.
+func (s *shimServer) start() { <-s.launch() }
+
+// launch starts a process for the server, unless one is running or
+// starting already, or the server has been stopped, and returns a
+// channel that is closed when the process is ready or failed to
+// start.
+func (s *shimServer) launch() <-chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.starting != nil {
+		return s.starting
+	}
+
 	ready := make(chan struct{})
-	go func() {
-		s.Lock()
-		if s.running {
-			s.Unlock()
-			close(ready)
-			return
+	if s.running || s.restarting || s.terminated {
+		close(ready)
+		return ready
+	}
+
+	s.starting = ready
+	go s.run(ready)
+
+	return ready
+}
+
+// run starts the process and supervises it until it stops. The server
+// is not locked while the process becomes ready, so that callers can
+// stop waiting for it.
+func (s *shimServer) run(ready chan struct{}) {
+	s.Lock()
+
+	// failed must be called with the lock held.
+	failed := func(msg string) {
+		s.recordError(msg)
+		s.starting = nil
+		select {
+		case <-s.terminate:
+			s.terminated = true
+		default:
 		}
+		s.Unlock()
+		close(ready)
+	}
 
-		if err := s.backend.writeFiles(s.workingDirectory); err != nil {
-			s.errors = append(s.errors, err.Error())
-			s.Unlock()
-			close(ready)
-			return
+	terminate := s.terminate
+
+	select {
+	case <-terminate:
+		failed(errStoppedDuringStartup.Error())
+		return
+	default:
+	}
+
+	if s.workingDirectory == "" {
+		failed("backend has no working directory")
+		return
+	}
+
+	if err := s.backend.writeFiles(s.workingDirectory); err != nil {
+		failed(err.Error())
+		return
+	}
+
+	cmd := s.backend.commandFor(s.conf.runtime, s.workingDirectory, s.address)
+	if cmd == nil {
+		failed("unsupported backend")
+		return
+	}
+
+	// the end of the service's output explains most startup
+	// failures, such as missing modules.
+	stderr := &outputTail{}
+	if cmd.Stderr == nil {
+		cmd.Stderr = stderr
+	}
+	configureProcess(cmd)
+	passToken(cmd, s.token)
+
+	err := s.attachTransport(cmd)
+	if err == nil {
+		err = cmd.Start()
+	}
+
+	if err != nil {
+		failed(err.Error())
+		return
+	}
+
+	var exitErr error
+	exited := make(chan struct{})
+	output := s.outputDone()
+	go func() {
+		<-output
+		exitErr = cmd.Wait()
+		close(exited)
+	}()
+
+	s.Unlock()
+	err = s.waitUntilReady(exited, terminate)
+	s.Lock()
+
+	if err != nil {
+		if err == errExitedDuringStartup {
+			err = fmt.Errorf("%w: %v", err, exitErr)
 		}
+		if output := stderr.String(); output != "" {
+			err = fmt.Errorf("%w\n%s", err, output)
+		}
+
+		killProcess(cmd)
+		<-exited
+		failed(err.Error())
+		return
+	}
 
-		defer os.RemoveAll(s.workingDirectory)
+	s.pid = cmd.Process.Pid
+	started := time.Now()
 
-		cmd := s.backend.getCommand(s.workingDirectory, s.port)
-		if cmd == nil {
-			s.errors = append(s.errors, "unsupported backend")
-			s.Unlock()
-			close(ready)
+	s.running = true
+	s.starting = nil
+	s.touch()
+	s.errors = []string{}
+	atomic.StoreInt64(&s.failed, 0)
+	s.Unlock()
+
+	close(ready)
+
+	select {
+	case <-terminate:
+		stopProcess(cmd, exited, s.conf.grace)
+	case <-exited:
+		killProcess(cmd)
+		if s.restartAfterCrash(exitErr, time.Since(started), terminate) {
 			return
 		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.closeTransport()
+	s.terminated = true
+	s.running = false
+	s.pid = 0
+	s.doneRestarting()
+	s.removeWorkingDirectory()
 
-		err := cmd.Start()
+	close(s.closed)
+}
 
-		if err != nil {
-			s.errors = append(s.errors, err.Error())
-			s.Unlock()
-			close(ready)
-			return
+var (
+	errExitedDuringStartup  = errors.New("backend exited during startup")
+	errStoppedDuringStartup = errors.New("backend was stopped during startup")
+)
+
+// waitUntilReady polls the service's health endpoint until it reports
+// that it is running, giving up early if its process exits or the
+// server is stopped. It must be called while the server is starting,
+// which keeps its address from changing.
+func (s *shimServer) waitUntilReady(exited, terminate <-chan struct{}) error {
+	policy := s.conf.startup
+	ctx, cancel := context.WithTimeout(context.Background(), policy.Timeout)
+	defer cancel()
+
+	for {
+		err := s.probe(ctx)
+		if err == nil {
+			return nil
 		}
 
-		err = retry(10, 100*time.Millisecond, func() (err error) {
-			response, err := http.DefaultClient.Get(s.uri)
-			if err == nil && response.StatusCode != 200 {
-				err = fmt.Errorf("non-200 status code")
-			}
-			return
-		})
-		if err != nil {
-			s.errors = append(s.errors, "failed to ping backend "+err.Error())
-			s.Unlock()
-			close(ready)
-			return
+		select {
+		case <-exited:
+			return errExitedDuringStartup
+		case <-terminate:
+			return errStoppedDuringStartup
+		case <-ctx.Done():
+			return fmt.Errorf("backend was not ready after %s, last error: %s", policy.Timeout, err)
+		case <-time.After(policy.PollInterval):
 		}
+	}
+}
+
+// probe checks that the service's overview reports it running. It
+// must be called while the server is starting.
+func (s *shimServer) probe(ctx context.Context) error {
+	req, err := http.NewRequest("GET", s.uri+s.backend.healthPath(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(tokenHeader, s.token)
+
+	response, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return fmt.Errorf("health check returned %s", response.Status)
+	}
+
+	overview := struct {
+		Status string `json:"status"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(response.Body, maxOverviewSize)).Decode(&overview); err != nil {
+		return fmt.Errorf("health check returned an invalid overview: %w", err)
+	}
+
+	if overview.Status != "running" {
+		return fmt.Errorf("backend reported status '%s'", overview.Status)
+	}
+
+	return nil
+}
 
-		s.pid = cmd.Process.Pid
+// restartAfterCrash marks the server down after its process exited
+// on its own, and starts a new process after a delay that doubles with
+// each crash of a process that did not stay up for long. It returns
+// false if the server was stopped before the new process started.
+func (s *shimServer) restartAfterCrash(err error, uptime time.Duration, terminate chan struct{}) bool {
+	s.Lock()
+	s.running = false
+	s.pid = 0
+	s.closeTransport()
+	s.restarts++
+	if uptime > maxRestartBackoff {
+		s.crashes = 0
+	}
+	s.crashes++
+	delay := restartBackoff(s.crashes)
+	s.restarting = true
+	s.restarted = make(chan struct{})
+	s.Unlock()
 
-		s.running = true
+	select {
+	case <-terminate:
+	case <-time.After(delay):
+	}
+
+	s.Lock()
+	select {
+	case <-terminate:
+		// run marks the server terminated before the restart is
+		// done, so that callers waiting for it don't start it again.
 		s.Unlock()
+		return false
+	default:
+	}
+	s.doneRestarting()
+	s.setup()
+	s.Unlock()
 
-		close(ready)
+	s.start()
 
-		<-s.terminate
-		cmd.Process.Kill()
+	return true
+}
 
-		s.Lock()
-		defer s.Unlock()
-		s.terminated = true
-		s.running = false
-		s.pid = 0
+// doneRestarting releases the callers waiting for a restart. It must
+// be called with exclusive access to the server.
+func (s *shimServer) doneRestarting() {
+	s.restarting = false
+	if s.restarted != nil {
+		close(s.restarted)
+		s.restarted = nil
+	}
+}
 
-		close(s.closed)
-	}()
+func restartBackoff(crashes int) time.Duration {
+	delay := minRestartBackoff
+	for i := 1; i < crashes && delay < maxRestartBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRestartBackoff {
+		return maxRestartBackoff
+	}
 
-	<-ready
+	return delay
 }
 
 func (s *shimServer) stop() {
@@ -151,7 +425,24 @@ func (s *shimServer) stop() {
 		return
 	}
 
-	if !s.isRunning() {
+	// a process that is starting is stopped before it is ready.
+	s.Lock()
+	starting := s.starting
+	if starting != nil {
+		s.closeTerminate()
+	}
+	s.Unlock()
+	if starting != nil {
+		<-starting
+	}
+
+	if !s.isRunning() && !s.isRestarting() {
+		// the server never ran, or failed to start, but its working
+		// directory exists.
+		s.Lock()
+		s.terminated = true
+		s.removeWorkingDirectory()
+		s.Unlock()
 		return
 	}
 
@@ -159,10 +450,36 @@ func (s *shimServer) stop() {
 }
 
 func (s *shimServer) terminateServer() {
+	s.Lock()
+	s.closeTerminate()
+	closed := s.closed
+	s.Unlock()
+	<-closed
+}
+
+// closeTerminate must be called with exclusive access to the server.
+func (s *shimServer) closeTerminate() {
+	select {
+	case <-s.terminate:
+	default:
+		close(s.terminate)
+	}
+}
+
+// restartIfHung kills a backend that did not respond before a
+// deadline so that the next conversion starts a fresh process. The
+// pid guards against restarting a process that another caller has
+// already replaced.
+func (s *shimServer) restartIfHung(pid int) {
 	s.RLock()
-	close(s.terminate)
+	current := s.pid
 	s.RUnlock()
-	<-s.closed
+
+	if pid == 0 || current != pid {
+		return
+	}
+
+	s.reset()
 }
 
 func (s *shimServer) reset() {
@@ -173,22 +490,99 @@ func (s *shimServer) reset() {
 	s.setup()
 }
 
-func (s *shimServer) startIfNeeded() error {
+// startIfNeeded starts the server's process if it is not running,
+// retrying as the startup policy allows, or waits for it to restart
+// after a crash. It stops waiting when the context is done, but a
+// process that is starting keeps starting, so that a later call can
+// use it.
+func (s *shimServer) startIfNeeded(ctx context.Context) error {
 	if s.isRunning() {
 		return nil
 	}
 
 	if s.hasTerminated() {
-		return nil
+		return fmt.Errorf("%w: server has been stopped", ErrBackendUnavailable)
+	}
+
+	if restarted := s.getRestarted(); restarted != nil {
+		select {
+		case <-restarted:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for the backend to restart: %w", ctx.Err())
+		}
+
+		return s.startIfNeeded(ctx)
 	}
 
+	policy := s.conf.startup
 	if s.hasError() {
-		return s.getError()
+		if s.coolingDown() {
+			return fmt.Errorf("%w: %s", ErrBackendUnavailable, s.getError())
+		}
+
+		s.retryPrepare()
 	}
 
-	s.start()
+	delay := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-s.launch():
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for the backend to start: %w", ctx.Err())
+		}
+
+		if s.isRunning() {
+			return nil
+		}
+
+		if s.hasTerminated() {
+			return fmt.Errorf("%w: server has been stopped", ErrBackendUnavailable)
+		}
+
+		if attempt >= policy.Attempts {
+			break
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for the backend to start: %w", ctx.Err())
+		}
+		delay *= 2
+
+		// the server may have been stopped during the delay.
+		if s.hasTerminated() {
+			return fmt.Errorf("%w: server has been stopped", ErrBackendUnavailable)
+		}
+		s.retryPrepare()
+	}
+
+	s.markFailed()
+
+	return fmt.Errorf("%w: %s", ErrBackendUnavailable, s.getError())
+}
+
+func (s *shimServer) markFailed() { atomic.StoreInt64(&s.failed, time.Now().UnixNano()) }
+
+// coolingDown reports whether the server failed to start too recently
+// to try again. It doesn't lock the server, so that it can be checked
+// while the server starts.
+func (s *shimServer) coolingDown() bool {
+	failed := atomic.LoadInt64(&s.failed)
+
+	return failed != 0 && time.Since(time.Unix(0, failed)) < s.conf.startup.Cooldown
+}
+
+// retryPrepare gives the next attempt to start the server a fresh
+// working directory and address, unless another caller has started it
+// or it has been stopped in the meantime.
+func (s *shimServer) retryPrepare() {
+	s.Lock()
+	defer s.Unlock()
 
-	return s.getError()
+	if !s.running && s.starting == nil && !s.terminated {
+		s.prepare()
+	}
 }
 
 func (s *shimServer) isRunning() bool {
@@ -198,6 +592,33 @@ func (s *shimServer) isRunning() bool {
 	return s.running
 }
 
+func (s *shimServer) isRestarting() bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.restarting
+}
+
+// getRestarted returns a channel that is closed when the server is
+// done restarting after a crash, or nil if it is not restarting.
+func (s *shimServer) getRestarted() <-chan struct{} {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.restarting {
+		return nil
+	}
+
+	return s.restarted
+}
+
+func (s *shimServer) getRestarts() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.restarts
+}
+
 func (s *shimServer) hasTerminated() bool {
 	s.RLock()
 	defer s.RUnlock()
@@ -225,6 +646,13 @@ func (s *shimServer) formatIsSupported(f Format) bool {
 	return false
 }
 
+func (s *shimServer) getClient() *http.Client {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.client
+}
+
 func (s *shimServer) getURI(path string) string {
 	s.RLock()
 	defer s.RUnlock()
@@ -232,6 +660,13 @@ func (s *shimServer) getURI(path string) string {
 	return strings.Join([]string{s.uri, path}, "/")
 }
 
+func (s *shimServer) getToken() string {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.token
+}
+
 func (s *shimServer) getError() error {
 	s.RLock()
 	defer s.RUnlock()
@@ -243,61 +678,228 @@ func (s *shimServer) getError() error {
 	return errors.New(strings.Join(s.errors, "\n"))
 }
 
-func (s *shimServer) doConversion(format Format, input []byte) ([]byte, error) {
-	if err := s.startIfNeeded(); err != nil {
-		return nil, fmt.Errorf("error problem starting '%s' server: %s", format, err.Error())
+func (s *shimServer) getPid() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.pid
+}
+
+// post sends the input to the backend's route for the format, or to
+// a sub-route of it, and returns the response if the backend reported
+// success. The caller must close the response body, and should pass
+// errors encountered while reading it to checkDeadline along with the
+// returned pid.
+func (s *shimServer) post(ctx context.Context, route string, format Format, input io.Reader, accept string, opts Options) (*http.Response, int, error) {
+	query, err := opts.query()
+	if err != nil {
+		return nil, 0, err
 	}
 
-	response, err := http.DefaultClient.Post(s.getURI(string(format)), "text/plain", bytes.NewReader(input))
+	uri := s.getURI(route)
+	if query != "" {
+		uri += "?" + query
+	}
+
+	req, err := http.NewRequest(http.MethodPost, uri, input)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Accept", accept)
+	req.Header.Set(tokenHeader, s.getToken())
+
+	pid := s.getPid()
+	response, err := s.getClient().Do(req.WithContext(ctx))
+	if err != nil {
+		s.checkDeadline(ctx, pid)
+		return nil, pid, newRequestError(ctx, format, err)
+	}
+
 	if response.StatusCode != 200 {
-		return nil, errors.New(response.Status)
+		defer response.Body.Close()
+		return nil, pid, newStatusError(format, response)
 	}
 
-	output := bytes.NewBuffer([]byte{})
-	_, err = io.Copy(output, response.Body)
-	response.Body.Close()
+	return response, pid, nil
+}
+
+func (s *shimServer) checkDeadline(ctx context.Context, pid int) {
+	if ctx.Err() == context.DeadlineExceeded {
+		s.restartIfHung(pid)
+	}
+}
+
+type conversionResult struct {
+	Content     string            `json:"content"`
+	Info        string            `json:"info"`
+	Diagnostics []Diagnostic      `json:"diagnostics"`
+	Document    *Document         `json:"document"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+func (r *conversionResult) warning(format Format) error {
+	if r.Info == "" && len(r.Diagnostics) == 0 {
+		return nil
+	}
+
+	return &ConversionWarning{Format: format, Info: r.Info, Diagnostics: r.Diagnostics}
+}
+
+func (s *shimServer) doConversion(ctx context.Context, format Format, input []byte, opts Options) (*conversionResult, error) {
+	result, err := s.convert(ctx, string(format), format, input, opts)
 	if err != nil {
+		transportErr := &TransportError{}
+		if opts.Output != "" && errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusBadRequest {
+			return nil, fmt.Errorf("%w: '%s' backend cannot write %s: %s", ErrUnsupportedFormat, format, opts.Output, err)
+		}
 		return nil, err
 	}
 
-	data := &struct {
-		Content string
-		Info    string
-	}{}
+	return result, nil
+}
+
+func (s *shimServer) doDocument(ctx context.Context, format Format, input []byte, opts Options) (*conversionResult, error) {
+	result, err := s.convert(ctx, string(format)+"/document", format, input, opts)
+	if err != nil {
+		transportErr := &TransportError{}
+		if errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: '%s' backend cannot return document parts", ErrUnsupportedFormat, format)
+		}
+		return nil, err
+	}
+
+	if result.Document == nil {
+		return nil, fmt.Errorf("'%s' backend did not return a document", format)
+	}
+
+	return result, nil
+}
+
+func (s *shimServer) doMetadata(ctx context.Context, format Format, input []byte) (map[string]string, error) {
+	result, err := s.convert(ctx, string(format)+"/metadata", format, input, Options{})
+	if err != nil {
+		transportErr := &TransportError{}
+		if errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: '%s' backend cannot extract metadata", ErrUnsupportedFormat, format)
+		}
+		return nil, err
+	}
+
+	if result.Metadata == nil {
+		return map[string]string{}, nil
+	}
+
+	return result.Metadata, nil
+}
+
+func (s *shimServer) convert(ctx context.Context, route string, format Format, input []byte, opts Options) (*conversionResult, error) {
+	if err := s.startIfNeeded(ctx); err != nil {
+		return nil, fmt.Errorf("error problem starting '%s' server: %w", format, err)
+	}
 
-	if err := json.Unmarshal(output.Bytes(), data); err != nil {
+	response, pid, err := s.post(ctx, route, format, bytes.NewReader(input), "application/json", opts)
+	if err != nil {
 		return nil, err
 	}
+	defer response.Body.Close()
 
-	out := []byte(data.Content)
+	body := &bodyReader{r: response.Body}
+	data := &conversionResult{}
+	if err := json.NewDecoder(body).Decode(data); err != nil {
+		s.checkDeadline(ctx, pid)
+		return nil, newDecodeError(ctx, format, body, err)
+	}
 
-	if data.Info != "" {
-		return out, errors.New(data.Info)
+	return data, nil
+}
+
+// doStream converts the input without buffering either document: the
+// backend returns the rendered content as the response body, and the
+// warnings in the X-Shimgo-Info and X-Shimgo-Diagnostics headers as
+// JSON. Backends that answer with their JSON result instead are
+// decoded, which buffers the output.
+func (s *shimServer) doStream(ctx context.Context, format Format, input io.Reader, output io.Writer) error {
+	if err := s.startIfNeeded(ctx); err != nil {
+		return fmt.Errorf("error problem starting '%s' server: %w", format, err)
 	}
 
-	return []byte(out), nil
+	response, pid, err := s.post(ctx, string(format), format, input, "text/html", Options{})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if isJSON(response) {
+		body := &bodyReader{r: response.Body}
+		result := &conversionResult{}
+		if err := json.NewDecoder(body).Decode(result); err != nil {
+			s.checkDeadline(ctx, pid)
+			return newDecodeError(ctx, format, body, err)
+		}
+
+		if _, err := io.WriteString(output, result.Content); err != nil {
+			return err
+		}
+
+		return result.warning(format)
+	}
+
+	if _, err := io.Copy(output, response.Body); err != nil {
+		s.checkDeadline(ctx, pid)
+		return newRequestError(ctx, format, err)
+	}
+
+	result := &conversionResult{}
+	if header := response.Header.Get("X-Shimgo-Info"); header != "" {
+		if err := json.Unmarshal([]byte(header), &result.Info); err != nil {
+			return err
+		}
+	}
+	if header := response.Header.Get("X-Shimgo-Diagnostics"); header != "" {
+		if err := json.Unmarshal([]byte(header), &result.Diagnostics); err != nil {
+			return err
+		}
+	}
+
+	return result.warning(format)
+}
+
+func isJSON(response *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+
+	return mediaType == "application/json"
 }
 
-func (s *shimServer) supportsConversion(format Format) error {
+func (s *shimServer) supportsConversion(ctx context.Context, format Format) error {
 	if s.formatIsSupported(format) {
 		return nil
 	}
 
-	if err := s.startIfNeeded(); err != nil {
-		return fmt.Errorf("problem starting service for '%s': %s", format, err.Error())
+	if err := s.startIfNeeded(ctx); err != nil {
+		return fmt.Errorf("problem starting service for '%s': %w", format, err)
+	}
 
+	req, err := http.NewRequest(http.MethodGet, s.getURI("support/"+string(format)), nil)
+	if err != nil {
+		return err
 	}
+	req.Header.Set(tokenHeader, s.getToken())
 
-	response, err := http.DefaultClient.Get(s.getURI("support/" + string(format)))
+	response, err := s.getClient().Do(req.WithContext(ctx))
 	if err != nil {
-		return fmt.Errorf("got error checking conversion server: %s", err.Error())
+		return fmt.Errorf("got error checking conversion server: %w", newRequestError(ctx, format, err))
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusBadRequest {
+		// the body explains why, e.g. a module that the interpreter
+		// is missing.
+		return fmt.Errorf("%w: %v", ErrUnsupportedFormat, newStatusError(format, response))
 	}
 
 	if response.StatusCode != 200 {
-		return fmt.Errorf("got '%s' checking conversion server", response.Status)
+		return fmt.Errorf("got error checking conversion server: %w", newStatusError(format, response))
 	}
 
 	s.Lock()
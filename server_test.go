@@ -1,18 +1,24 @@
 package shimgo
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 )
 
 func TestServersHaveCorrectInitialValues(t *testing.T) {
-	assert(t, serverCache != nil, "global instance is initialized")
+	assert(t, defaultConverter != nil, "global instance is initialized")
 
-	assert(t, len(serverCache.backends) == 3, "servers are less than expected")
-	for format, server := range serverCache.backends {
+	assert(t, len(defaultConverter.servers.backends) == 3, "servers are less than expected")
+	for format, server := range defaultConverter.servers.backends {
 		t.Run(fmt.Sprint(format), func(t *testing.T) {
 			assert(t, len(server.errors) == 0, "there are no errors")
 			assert(t, len(server.workingDirectory) != 0, "working is defined", server.workingDirectory)
@@ -27,7 +33,7 @@ func TestServersHaveCorrectInitialValues(t *testing.T) {
 }
 
 func TestAddError(t *testing.T) {
-	for format, s := range serverCache.backends {
+	for format, s := range defaultConverter.servers.backends {
 		t.Run(fmt.Sprint(format), func(t *testing.T) {
 			assert(t, len(s.errors) == 0, "there should be no errors and there are:", len(s.errors))
 			assert(t, !s.hasError(), "has error should not report error but does")
@@ -55,7 +61,7 @@ func TestAddError(t *testing.T) {
 			}
 			wg.Wait()
 
-			assert(t, len(s.errors) == 201, "there are many errors (201), and are actually:", len(s.errors))
+			assert(t, len(s.errors) == maxErrors, "only the most recent errors are kept, and there are:", len(s.errors))
 			assert(t, s.hasError(), "has error should report error but does not")
 			assert(t, s.getError() != nil, "get error should not be nil")
 
@@ -65,7 +71,7 @@ func TestAddError(t *testing.T) {
 }
 
 func TestStartingService(t *testing.T) {
-	for format, s := range serverCache.backends {
+	for format, s := range defaultConverter.servers.backends {
 		t.Run(fmt.Sprint(format), func(t *testing.T) {
 			require(t, !s.running, "server shouldn't be running at start, but is", fmt.Sprintf("%+v", s))
 			assert(t, !s.isRunning(), "isRunning method should reflect that server is not yet running")
@@ -80,25 +86,25 @@ func TestStartingService(t *testing.T) {
 }
 
 func TestStartIfNeeded(t *testing.T) {
-	for format, s := range serverCache.backends {
+	for format, s := range defaultConverter.servers.backends {
 		t.Run(fmt.Sprint(format), func(t *testing.T) {
 			require(t, !s.running, "server shouldn't be running at start, but is", fmt.Sprintf("%+v", s))
 			assert(t, !s.isRunning(), "isRunning method should reflect that server is not yet running")
 
-			err := s.startIfNeeded()
+			err := s.startIfNeeded(context.Background())
 			require(t, err == nil, "server should not error when starting", fmt.Sprintf("%+v", err))
 			require(t, s.running, "server should be running after starting, but isn't", fmt.Sprintf("%+v", s))
 			assert(t, s.isRunning(), "isRunning method should reflect that server is running")
 			assert(t, s.pid != 0, "pid is set because server is running")
 			cleanup(t, s)
 
-			s = newServer(s.backend)
+			s = newServer(s.backend, s.conf)
 			s.addError(errors.New("blocker"))
 			assert(t, s.hasError(), "error should be here")
 			assert(t, !s.running, "server shouldn't start if it has errors")
 			assert(t, !s.isRunning(), "server isn't running and shouldn't report that")
 
-			err = s.startIfNeeded()
+			err = s.startIfNeeded(context.Background())
 			require(t, err != nil, "server should have mocked error")
 			assert(t, !s.running, "server shouldn't start if it has errors")
 			assert(t, !s.isRunning(), "server isn't running and shouldn't report that")
@@ -106,12 +112,12 @@ func TestStartIfNeeded(t *testing.T) {
 
 			cleanup(t, s)
 
-			s = newServer(s.backend)
+			s = newServer(s.backend, s.conf)
 			s.running = true
 			assert(t, s.isRunning(), "test faked running attribute and the method should reflect that")
 			assert(t, !s.hasError(), "no errrors")
 
-			err = s.startIfNeeded()
+			err = s.startIfNeeded(context.Background())
 			require(t, err == nil, "server should not error when starting", fmt.Sprintf("%+v", err))
 			assert(t, s.pid == 0, "pid isnt set because server is running")
 			assert(t, !s.hasError(), "no errrors")
@@ -122,7 +128,7 @@ func TestStartIfNeeded(t *testing.T) {
 }
 
 func TestErrorConditionsWhenStarting(t *testing.T) {
-	for format, s := range serverCache.backends {
+	for format, s := range defaultConverter.servers.backends {
 		t.Run(fmt.Sprint(format), func(t *testing.T) {
 			wd := s.workingDirectory
 			_, err := os.Stat(wd)
@@ -146,7 +152,7 @@ func TestErrorConditionsWhenStarting(t *testing.T) {
 }
 
 func TestStartStop(t *testing.T) {
-	for format, s := range serverCache.backends {
+	for format, s := range defaultConverter.servers.backends {
 		t.Run(fmt.Sprint(format), func(t *testing.T) {
 			s.start()
 			assert(t, s.isRunning(), "server should run after its started")
@@ -161,7 +167,7 @@ func TestStartStop(t *testing.T) {
 }
 
 func TestServersStopIsSafeToRunAfterCleanup(t *testing.T) {
-	for format, s := range serverCache.backends {
+	for format, s := range defaultConverter.servers.backends {
 		t.Run(fmt.Sprint(format), func(t *testing.T) {
 			s.start()
 			assert(t, s.isRunning(), "server should run after its started")
@@ -177,3 +183,106 @@ func TestServersStopIsSafeToRunAfterCleanup(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamingConversion(t *testing.T) {
+	s := newFakeServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert(t, r.URL.Path == "/rst", "request should go to the format's route:", r.URL.Path)
+		assert(t, r.Header.Get("Accept") == "text/html", "streaming requests should accept html")
+
+		body, err := ioutil.ReadAll(r.Body)
+		assert(t, err == nil, "reading request body", err)
+
+		w.Header().Set("X-Shimgo-Info", `"line 1: warning"`)
+		w.Write(bytes.ToUpper(body))
+	}))
+
+	output := &bytes.Buffer{}
+	err := s.doStream(context.Background(), RST, strings.NewReader("content"), output)
+	assert(t, output.String() == "CONTENT", "streamed content should be written to the output:", output.String())
+	require(t, err != nil, "warnings should be reported as an error")
+	assert(t, err.Error() == "line 1: warning", "warning should be decoded from the header:", err)
+}
+
+func TestConversionDiagnostics(t *testing.T) {
+	s := newFakeServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content": "<p>text</p>", "info": "3: (WARNING/2) oops",
+			"diagnostics": [{"line": 3, "column": 0, "severity": "warning", "message": "oops", "source": "docutils"}]}`))
+	}))
+
+	result, err := s.doConversion(context.Background(), RST, []byte("text"), Options{})
+	require(t, err == nil, "conversion should succeed", err)
+	assert(t, result.Content == "<p>text</p>", "content should be decoded:", result.Content)
+	assert(t, result.warning(RST) != nil, "info should be reported as a warning")
+	require(t, len(result.Diagnostics) == 1, "there should be one diagnostic:", len(result.Diagnostics))
+
+	d := result.Diagnostics[0]
+	assert(t, d.Line == 3, "line should be decoded:", d.Line)
+	assert(t, d.Severity == SeverityWarning, "severity should be decoded:", d.Severity)
+	assert(t, d.String() == "docutils: warning: line 3: oops", "diagnostic should render its position:", d)
+}
+
+func TestConversionOptions(t *testing.T) {
+	var received Options
+	s := newFakeServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = Options{}
+		if query := r.URL.Query().Get("options"); query != "" {
+			err := json.Unmarshal([]byte(query), &received)
+			assert(t, err == nil, "options should be valid json", err)
+		}
+		w.Write([]byte(`{"content": "<p>text</p>"}`))
+	}))
+
+	opts := Options{Attributes: map[string]string{"icons": "font"}, SafeMode: "server", Doctype: "book"}
+	_, err := s.doConversion(context.Background(), ASCIIDOCTOR, []byte("text"), opts)
+	require(t, err == nil, "conversion should succeed", err)
+	assert(t, received.Attributes["icons"] == "font", "attributes should be sent:", received.Attributes)
+	assert(t, received.SafeMode == "server", "safe mode should be sent:", received.SafeMode)
+	assert(t, received.Doctype == "book", "doctype should be sent:", received.Doctype)
+
+	_, err = s.doConversion(context.Background(), ASCIIDOCTOR, []byte("text"), Options{})
+	require(t, err == nil, "conversion should succeed", err)
+	assert(t, received.isZero(), "default options should not be sent:", received)
+}
+
+func TestConvertDocument(t *testing.T) {
+	s := newFakeServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rst/document" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"content": "<h1>Title</h1><p>text</p>", "document": {
+			"title": "Title", "subtitle": "", "body": "<h1>Title</h1><p>text</p>",
+			"docinfo": "", "fragment": "<p>text</p>", "parts": {"title": "Title", "version": "0.14"},
+			"toc": [{"id": "usage", "title": "Usage", "level": 1, "children": []}]}}`))
+	}))
+
+	result, err := s.doDocument(context.Background(), RST, []byte("text"), Options{})
+	require(t, err == nil, "conversion should succeed", err)
+	doc := result.Document
+	assert(t, doc.Title == "Title", "title should be decoded:", doc.Title)
+	assert(t, doc.Fragment == "<p>text</p>", "fragment should be decoded:", doc.Fragment)
+	assert(t, doc.Parts["version"] == "0.14", "raw parts should be decoded:", doc.Parts)
+	require(t, len(doc.TOC) == 1, "table of contents should be decoded:", doc.TOC)
+	assert(t, doc.TOC[0].ID == "usage", "section id should be decoded:", doc.TOC[0])
+
+	_, err = s.doDocument(context.Background(), ASCIIDOC, []byte("text"), Options{})
+	assert(t, errors.Is(err, ErrUnsupportedFormat), "backends without a document route don't return parts:", err)
+}
+
+func TestExtractMetadata(t *testing.T) {
+	s := newFakeServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rst/metadata" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"metadata": {"title": "Title", "author": "A. Writer"}}`))
+	}))
+
+	metadata, err := s.doMetadata(context.Background(), RST, []byte("text"))
+	require(t, err == nil, "extraction should succeed", err)
+	assert(t, metadata["author"] == "A. Writer", "metadata should be decoded:", metadata)
+
+	_, err = s.doMetadata(context.Background(), ASCIIDOC, []byte("text"))
+	assert(t, errors.Is(err, ErrUnsupportedFormat), "backends without a metadata route don't support extraction:", err)
+}
@@ -1,25 +1,66 @@
 package shimgo
 
 import (
-	"fmt"
+	"context"
+	"io"
 )
 
-func Cleanup()                                           { serverCache.cleanup() }
-func Reset()                                             { serverCache.reset() }
-func SupportsRst() bool                                  { return serverCache.hasSupport(RST) }
-func SupportsAsciiDoc() bool                             { return serverCache.hasSupport(ASCIIDOC) }
-func SupportsAsciidoctor() bool                          { return serverCache.hasSupport(ASCIIDOCTOR) }
-func ConvertFromRst(content []byte) ([]byte, error)      { return convertHelper(RST, content) }
-func ConvertFromAsciiDoc(content []byte) ([]byte, error) { return convertHelper(ASCIIDOC, content) }
+var defaultConverter = NewConverter()
+
+func Cleanup()                                      { defaultConverter.Cleanup() }
+func Reset()                                        { defaultConverter.Reset() }
+func SupportsRst() bool                             { return defaultConverter.Supports(RST) }
+func SupportsAsciiDoc() bool                        { return defaultConverter.Supports(ASCIIDOC) }
+func SupportsAsciidoctor() bool                     { return defaultConverter.Supports(ASCIIDOCTOR) }
+func ConvertFromRst(content []byte) ([]byte, error) { return defaultConverter.Convert(RST, content) }
+func ConvertFromAsciiDoc(content []byte) ([]byte, error) {
+	return defaultConverter.Convert(ASCIIDOC, content)
+}
 func ConvertFromAsciidoctor(content []byte) ([]byte, error) {
-	return convertHelper(ASCIIDOCTOR, content)
+	return defaultConverter.Convert(ASCIIDOCTOR, content)
 }
-
-func convertHelper(f Format, content []byte) ([]byte, error) {
-	server, err := serverCache.getServer(f)
-	if err != nil {
-		return nil, fmt.Errorf("no suitable backend for '%s' was found: %s", f, err.Error())
-	}
-
-	return server.doConversion(f, content)
+func ConvertContext(ctx context.Context, f Format, content []byte) ([]byte, error) {
+	return defaultConverter.ConvertContext(ctx, f, content)
+}
+func ConvertStream(ctx context.Context, f Format, input io.Reader, output io.Writer) error {
+	return defaultConverter.ConvertStream(ctx, f, input, output)
+}
+func ConvertWithDiagnostics(f Format, content []byte) ([]byte, []Diagnostic, error) {
+	return defaultConverter.ConvertWithDiagnostics(f, content)
+}
+func ConvertWithDiagnosticsContext(ctx context.Context, f Format, content []byte) ([]byte, []Diagnostic, error) {
+	return defaultConverter.ConvertWithDiagnosticsContext(ctx, f, content)
+}
+func ConvertWithOptions(f Format, content []byte, opts Options) ([]byte, error) {
+	return defaultConverter.ConvertWithOptions(f, content, opts)
+}
+func ConvertWithOptionsContext(ctx context.Context, f Format, content []byte, opts Options) ([]byte, error) {
+	return defaultConverter.ConvertWithOptionsContext(ctx, f, content, opts)
+}
+func ConvertDocument(f Format, content []byte) (*Document, error) {
+	return defaultConverter.ConvertDocument(f, content)
+}
+func ConvertDocumentContext(ctx context.Context, f Format, content []byte) (*Document, error) {
+	return defaultConverter.ConvertDocumentContext(ctx, f, content)
+}
+func ExtractMetadata(f Format, content []byte) (map[string]string, error) {
+	return defaultConverter.ExtractMetadata(f, content)
+}
+func ExtractMetadataContext(ctx context.Context, f Format, content []byte) (map[string]string, error) {
+	return defaultConverter.ExtractMetadataContext(ctx, f, content)
+}
+func ConvertTo(f Format, output OutputFormat, content []byte) ([]byte, error) {
+	return defaultConverter.ConvertTo(f, output, content)
+}
+func ConvertToContext(ctx context.Context, f Format, output OutputFormat, content []byte) ([]byte, error) {
+	return defaultConverter.ConvertToContext(ctx, f, output, content)
+}
+func Restarts(f Format) int            { return defaultConverter.Restarts(f) }
+func CleanupOnSignal() (cancel func()) { return defaultConverter.CleanupOnSignal() }
+func Warmup(ctx context.Context, formats ...Format) error {
+	return defaultConverter.Warmup(ctx, formats...)
+}
+func Capabilities(f Format) (*BackendCapabilities, error) { return defaultConverter.Capabilities(f) }
+func CapabilitiesContext(ctx context.Context, f Format) (*BackendCapabilities, error) {
+	return defaultConverter.CapabilitiesContext(ctx, f)
 }
@@ -0,0 +1,296 @@
+package shimgo
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxFrameSize bounds the length prefix of a frame read from a worker,
+// so that a corrupt stream fails instead of allocating without limit.
+const maxFrameSize = 1 << 30
+
+// stdioRequest and stdioResponse are the frames exchanged with a
+// worker that uses TransportStdio. Each frame is a JSON object
+// preceded by its length as a 4 byte big-endian integer. Responses
+// carry the ID of their request, and may arrive in any order.
+type stdioRequest struct {
+	ID      uint64            `json:"id"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body"`
+}
+
+type stdioResponse struct {
+	ID      uint64            `json:"id"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// stdioConn is an http.RoundTripper that multiplexes requests over a
+// worker's stdin and stdout, so that the rest of the server does not
+// depend on the transport.
+type stdioConn struct {
+	w       io.WriteCloser
+	closed  sync.Once
+	nextID  uint64
+	pending map[uint64]chan *stdioResponse
+	err     error
+	done    chan struct{}
+	mu      sync.Mutex
+
+	// writing holds a value while a frame is written, so that frames
+	// are not interleaved.
+	writing chan struct{}
+
+	// abandoned is called when a request gives up on a frame that is
+	// partly written, which leaves the worker unable to read the rest
+	// of its input.
+	abandoned func()
+}
+
+func newStdioConn(w io.WriteCloser, r io.Reader) *stdioConn {
+	c := &stdioConn{
+		w:         w,
+		pending:   map[uint64]chan *stdioResponse{},
+		done:      make(chan struct{}),
+		writing:   make(chan struct{}, 1),
+		abandoned: func() {},
+	}
+	go c.read(r)
+
+	return c
+}
+
+func writeFrame(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	_, err = w.Write(append(frame, data...))
+
+	return err
+}
+
+func readFrame(r io.Reader, v interface{}) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes is too large", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// read delivers the worker's responses until its output ends. The
+// rest of a corrupt stream is discarded, so that the worker doesn't
+// block writing to it.
+func (c *stdioConn) read(r io.Reader) {
+	defer close(c.done)
+
+	br := bufio.NewReader(r)
+	for {
+		resp := &stdioResponse{}
+		if err := readFrame(br, resp); err != nil {
+			c.fail(err)
+			io.Copy(ioutil.Discard, br)
+			return
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.mu.Unlock()
+
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// fail closes the connection once the worker's output ends, which
+// fails every outstanding request.
+func (c *stdioConn) fail(err error) {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = fmt.Errorf("worker closed its output: %w", err)
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+func (c *stdioConn) getError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+func (c *stdioConn) forget(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, id)
+}
+
+func (c *stdioConn) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		defer req.Body.Close()
+
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	headers := map[string]string{}
+	for _, name := range []string{"Accept", "Content-Type", tokenHeader} {
+		if value := req.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	ch := make(chan *stdioResponse, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	err := c.write(req.Context(), stdioRequest{
+		ID:      id,
+		Method:  req.Method,
+		Path:    path,
+		Query:   req.URL.RawQuery,
+		Headers: headers,
+		Body:    string(body),
+	})
+	if err != nil {
+		c.forget(id)
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, c.getError()
+		}
+
+		return resp.httpResponse(req), nil
+	case <-req.Context().Done():
+		c.forget(id)
+		return nil, req.Context().Err()
+	}
+}
+
+// write sends the frame once the frames before it are written, unless
+// the context is done first. A frame that is abandoned part way
+// through corrupts the worker's input, so the connection fails and the
+// worker is stopped.
+func (c *stdioConn) write(ctx context.Context, frame stdioRequest) error {
+	select {
+	case c.writing <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		written <- writeFrame(c.w, frame)
+		<-c.writing
+	}()
+
+	select {
+	case err := <-written:
+		return err
+	case <-ctx.Done():
+	}
+
+	select {
+	case err := <-written:
+		return err
+	default:
+	}
+
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = fmt.Errorf("abandoned a partly written request: %w", ctx.Err())
+	}
+	c.mu.Unlock()
+
+	c.Close()
+	c.abandoned()
+
+	return ctx.Err()
+}
+
+// Close closes the worker's stdin, which stops a worker that is
+// waiting for requests, and unblocks a frame that is being written.
+func (c *stdioConn) Close() error {
+	var err error
+	c.closed.Do(func() { err = c.w.Close() })
+
+	return err
+}
+
+func (r *stdioResponse) httpResponse(req *http.Request) *http.Response {
+	header := http.Header{}
+	for name, value := range r.Headers {
+		header.Set(name, value)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,149 @@
+package shimgo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStdioTransport(t *testing.T) {
+	requests, worker := io.Pipe()
+	output, responses := io.Pipe()
+	conn := newStdioConn(worker, output)
+	client := &http.Client{Transport: conn}
+
+	// the fake worker answers each pair of requests in reverse order,
+	// so that responses must be matched to requests by id.
+	go func() {
+		for {
+			var pair [2]stdioRequest
+			for i := range pair {
+				if err := readFrame(requests, &pair[i]); err != nil {
+					responses.Close()
+					return
+				}
+			}
+
+			for i := len(pair) - 1; i >= 0; i-- {
+				req := pair[i]
+				writeFrame(responses, stdioResponse{
+					ID:      req.ID,
+					Status:  200,
+					Headers: map[string]string{"Content-Type": "text/plain"},
+					Body:    req.Path + "?" + req.Query + ":" + req.Headers["Accept"] + ":" + req.Body,
+				})
+			}
+		}
+	}()
+
+	wg := &sync.WaitGroup{}
+	for _, body := range []string{"one", "two"} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+
+			req, err := http.NewRequest("POST", "http://stdio/rst?options=x", strings.NewReader(body))
+			require(t, err == nil, "creating request", err)
+			req.Header.Set("Accept", "text/html")
+
+			resp, err := client.Do(req)
+			require(t, err == nil, "request should succeed", err)
+			defer resp.Body.Close()
+
+			out, _ := ioutil.ReadAll(resp.Body)
+			assert(t, resp.StatusCode == 200, "status should be passed through", resp.Status)
+			assert(t, resp.Header.Get("Content-Type") == "text/plain", "headers should be passed through")
+			assert(t, string(out) == "/rst?options=x:text/html:"+body, "response should match the request:", string(out))
+		}(body)
+	}
+	wg.Wait()
+
+	// when the worker's output ends, outstanding and later requests
+	// fail.
+	responses.Close()
+	defer requests.Close()
+
+	_, err := client.Get("http://stdio")
+	require(t, err != nil, "requests should fail after the worker exits")
+	assert(t, isConnectionFailure(err), "the failure should be recognized as a crash:", err)
+	assert(t, !errors.Is(err, ErrBackendCrashed), "the transport does not know the context of the request")
+}
+
+func TestStdioRequestsUseTheContext(t *testing.T) {
+	// nothing reads the requests, as when the worker is busy with a
+	// conversion.
+	requests, worker := io.Pipe()
+	defer requests.Close()
+	output, responses := io.Pipe()
+	defer responses.Close()
+
+	conn := newStdioConn(worker, output)
+	var abandoned int32
+	conn.abandoned = func() { atomic.AddInt32(&abandoned, 1) }
+	client := &http.Client{Transport: conn}
+
+	post := func(ctx context.Context) error {
+		req, err := http.NewRequest("POST", "http://stdio/rst", strings.NewReader(strings.Repeat("x", 1<<20)))
+		require(t, err == nil, "creating request", err)
+
+		_, err = client.Do(req.WithContext(ctx))
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() { first <- post(ctx) }()
+
+	// the second request waits for the first one's frame to be
+	// written, until its deadline.
+	time.Sleep(50 * time.Millisecond)
+	short, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	began := time.Now()
+	err := post(short)
+	assert(t, errors.Is(err, context.DeadlineExceeded), "waiting requests should stop at their deadline:", err)
+	assert(t, time.Since(began) < time.Second, "waiting requests should not block:", time.Since(began))
+	assert(t, atomic.LoadInt32(&abandoned) == 0, "requests that wrote nothing should not stop the worker")
+
+	cancel()
+	select {
+	case err = <-first:
+	case <-time.After(5 * time.Second):
+		t.Fatal("requests that are writing should stop when their context is done")
+	}
+	assert(t, errors.Is(err, context.Canceled), "the request should report its context:", err)
+	assert(t, atomic.LoadInt32(&abandoned) == 1, "abandoning a partly written frame should stop the worker")
+
+	err = post(context.Background())
+	assert(t, err != nil, "requests should fail once the input is corrupt")
+}
+
+func TestStdioOutputIsReadToTheEnd(t *testing.T) {
+	_, worker := io.Pipe()
+	output, responses := io.Pipe()
+	conn := newStdioConn(worker, output)
+
+	// a corrupt frame fails the connection, but the rest of the output
+	// is still read, so that the worker doesn't block writing it.
+	_, err := responses.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	require(t, err == nil, "writing the frame header", err)
+	_, err = responses.Write([]byte("more output"))
+	require(t, err == nil, "output after a corrupt frame should be read", err)
+
+	select {
+	case <-conn.done:
+		t.Fatal("the output should be read until it ends")
+	default:
+	}
+
+	responses.Close()
+	<-conn.done
+	assert(t, conn.getError() != nil, "corrupt frames should fail the connection")
+}
@@ -0,0 +1,230 @@
+package shimgo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthFiles and staticServer run a backend that only answers its
+// health check, by serving its working directory.
+var healthFiles = map[string][]byte{"health.json": []byte(`{"status": "running"}`)}
+
+func staticServer(python, wd, port string) *exec.Cmd {
+	return exec.Command(python, "-m", "http.server", port, "--bind", "127.0.0.1", "--directory", wd)
+}
+
+func TestRestartBackoff(t *testing.T) {
+	assert(t, restartBackoff(1) == minRestartBackoff, "first restart should use the minimum delay")
+	assert(t, restartBackoff(2) == 2*minRestartBackoff, "delay should double with each crash")
+	assert(t, restartBackoff(100) == maxRestartBackoff, "delay should be bounded")
+}
+
+func TestCrashedServerRestarts(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	spec := BackendSpec{
+		Files:      healthFiles,
+		HealthPath: "/health.json",
+		Command: func(wd, port string) *exec.Cmd {
+			return staticServer(python, wd, port)
+		},
+	}
+	registerTestBackend(t, "test-crash", spec)
+
+	s := newServer("test-crash", serverConfig{})
+	defer cleanup(t, s)
+
+	require(t, s.startIfNeeded(context.Background()) == nil, "server should start", s.getError())
+	pid := s.getPid()
+	proc, err := os.FindProcess(pid)
+	require(t, err == nil, "finding the process", err)
+	require(t, proc.Kill() == nil, "killing the process")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) && (!s.isRunning() || s.getPid() == pid) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	assert(t, s.isRunning(), "server should have been restarted", s.getError())
+	assert(t, s.getPid() != pid, "a new process should have been started")
+	assert(t, s.getRestarts() == 1, "restart should be counted:", s.getRestarts())
+
+	s.stop()
+	assert(t, s.hasTerminated(), "restarted servers should stop")
+}
+
+func TestStartupRetries(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	failures := 1
+	starts := 0
+	spec := BackendSpec{
+		Files:      healthFiles,
+		HealthPath: "/health.json",
+		Command: func(wd, port string) *exec.Cmd {
+			starts++
+			if starts <= failures {
+				return exec.Command("false")
+			}
+			return staticServer(python, wd, port)
+		},
+	}
+	registerTestBackend(t, "test-flaky", spec)
+
+	policy := StartupPolicy{Attempts: 2, Backoff: time.Millisecond, Cooldown: 50 * time.Millisecond}
+
+	s := newServer("test-flaky", serverConfig{startup: policy})
+	require(t, s.startIfNeeded(context.Background()) == nil, "server should start on the second attempt", s.getError())
+	assert(t, starts == 2, "server should have been started twice:", starts)
+	assert(t, !s.hasError(), "errors should be cleared after a successful start", s.getError())
+	cleanup(t, s)
+
+	starts, failures = 0, 3
+	s = newServer("test-flaky", serverConfig{startup: policy})
+	defer cleanup(t, s)
+
+	err = s.startIfNeeded(context.Background())
+	require(t, errors.Is(err, ErrBackendUnavailable), "server should give up after its attempts:", err)
+	assert(t, starts == 2, "server should stop after its attempts:", starts)
+
+	err = s.startIfNeeded(context.Background())
+	assert(t, errors.Is(err, ErrBackendUnavailable), "server should not start during the cool-down:", err)
+	assert(t, starts == 2, "server should not start during the cool-down:", starts)
+
+	time.Sleep(policy.Cooldown)
+	require(t, s.startIfNeeded(context.Background()) == nil, "server should start after the cool-down", s.getError())
+	assert(t, starts == 4, "server should have tried again:", starts)
+}
+
+func TestCleanupDuringStartupBackoff(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	var starts int32
+	spec := BackendSpec{
+		Files:      healthFiles,
+		HealthPath: "/health.json",
+		Command: func(wd, port string) *exec.Cmd {
+			if atomic.AddInt32(&starts, 1) == 1 {
+				return exec.Command("false")
+			}
+			return staticServer(python, wd, port)
+		},
+		Formats: []Format{"test-backoff-markup"},
+	}
+	registerTestBackend(t, "test-backoff", spec)
+
+	policy := StartupPolicy{Attempts: 2, Backoff: 300 * time.Millisecond}
+	c := NewConverter(WithStartupPolicy(policy))
+	defer c.Cleanup()
+
+	s := c.servers.pools["test-backoff"].primary()
+	started := make(chan error, 1)
+	go func() { started <- s.startIfNeeded(context.Background()) }()
+
+	// clean up once the first attempt failed, during the backoff.
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !s.hasError() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	require(t, s.hasError(), "the first attempt should fail")
+	c.Cleanup()
+
+	err = <-started
+	assert(t, errors.Is(err, ErrBackendUnavailable), "stopped servers should not start:", err)
+	assert(t, atomic.LoadInt32(&starts) == 1, "no process should start after cleanup:", atomic.LoadInt32(&starts))
+	assert(t, !s.isRunning() && s.getPid() == 0, "the server should not be running")
+
+	<-s.launch()
+	assert(t, !s.isRunning(), "stopped servers should not launch processes")
+	assert(t, atomic.LoadInt32(&starts) == 1, "no process should start after cleanup:", atomic.LoadInt32(&starts))
+}
+
+func TestReadinessProbe(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	spec := BackendSpec{
+		Files: map[string][]byte{"health.json": []byte(`{"status": "starting"}`)},
+		Command: func(wd, port string) *exec.Cmd {
+			return staticServer(python, wd, port)
+		},
+	}
+	registerTestBackend(t, "test-unready", spec)
+
+	policy := StartupPolicy{Attempts: 1, Timeout: 500 * time.Millisecond, PollInterval: 50 * time.Millisecond}
+
+	s := newServer("test-unready", serverConfig{startup: policy})
+	defer cleanup(t, s)
+
+	began := time.Now()
+	err = s.startIfNeeded(context.Background())
+	require(t, err != nil, "backends whose overview is not JSON should not be ready")
+	assert(t, time.Since(began) >= policy.Timeout, "startup should wait for the timeout")
+	assert(t, strings.Contains(err.Error(), "not ready after 500ms"), "error should report the timeout:", err)
+
+	spec.HealthPath = "/health.json"
+	require(t, RegisterBackend("test-unready", spec) == nil, "registering backend")
+
+	s = newServer("test-unready", serverConfig{startup: policy})
+	defer cleanup(t, s)
+
+	err = s.startIfNeeded(context.Background())
+	require(t, err != nil, "backends that are not running should not be ready")
+	assert(t, strings.Contains(err.Error(), "status 'starting'"), "error should report the status:", err)
+}
+
+func TestConversionsAvoidRestartingWorkers(t *testing.T) {
+	registerFakeBackend(t, "test-restarting", "test-restarting-markup")
+
+	for _, workers := range []int{1, 2} {
+		c := NewConverter(WithWorkers(workers))
+		cleanupConverter(t, c)
+
+		// start every worker in the pool.
+		p := c.servers.pools["test-restarting"]
+		started := []*shimServer{}
+		for i := 0; i < workers; i++ {
+			w := p.acquire()
+			require(t, w.startIfNeeded(context.Background()) == nil, "worker should start", w.getError())
+			started = append(started, w)
+		}
+		for _, w := range started {
+			w.release()
+		}
+		require(t, len(p.all()) == workers, "pool should have all of its workers:", len(p.all()))
+
+		crashed := p.primary()
+		proc, err := os.FindProcess(crashed.getPid())
+		require(t, err == nil, "finding the process", err)
+		require(t, proc.Kill() == nil, "killing the process")
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) && !crashed.isRestarting() {
+			time.Sleep(5 * time.Millisecond)
+		}
+		require(t, crashed.isRestarting(), "crashed worker should be restarting")
+
+		for i := 0; i < 5; i++ {
+			out, err := c.Convert("test-restarting-markup", []byte("text"))
+			require(t, err == nil, "conversions should not fail while a worker restarts:", workers, err)
+			assert(t, string(out) == "TEXT", "conversion should use a backend:", string(out))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package shimgo
+
+import (
+	"html"
+	"strings"
+)
+
+// Section is an entry in a document's table of contents. ID is the
+// anchor of the section's heading in the rendered HTML, Title is plain
+// text, and Level is the depth of the section, starting at 1 for the
+// top-level sections.
+type Section struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Level    int       `json:"level"`
+	Children []Section `json:"children"`
+}
+
+// RenderTOC renders the sections as nested <ul> lists that link to
+// the section headings. It returns an empty string when there are no
+// sections.
+func RenderTOC(sections []Section) string {
+	buf := &strings.Builder{}
+	renderSections(buf, sections)
+
+	return buf.String()
+}
+
+func renderSections(buf *strings.Builder, sections []Section) {
+	if len(sections) == 0 {
+		return
+	}
+
+	buf.WriteString("<ul>\n")
+	for _, section := range sections {
+		buf.WriteString("<li>")
+		if section.ID == "" {
+			buf.WriteString(html.EscapeString(section.Title))
+		} else {
+			buf.WriteString(`<a href="#`)
+			buf.WriteString(html.EscapeString(section.ID))
+			buf.WriteString(`">`)
+			buf.WriteString(html.EscapeString(section.Title))
+			buf.WriteString("</a>")
+		}
+
+		if len(section.Children) > 0 {
+			buf.WriteString("\n")
+			renderSections(buf, section.Children)
+		}
+		buf.WriteString("</li>\n")
+	}
+	buf.WriteString("</ul>\n")
+}
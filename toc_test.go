@@ -0,0 +1,28 @@
+package shimgo
+
+import (
+	"testing"
+)
+
+func TestRenderTOC(t *testing.T) {
+	assert(t, RenderTOC(nil) == "", "empty table of contents should render nothing")
+
+	toc := []Section{
+		{ID: "intro", Title: "Intro & Overview", Level: 1, Children: []Section{
+			{ID: "goals", Title: "Goals", Level: 2},
+		}},
+		{Title: "Untitled", Level: 1},
+	}
+
+	expected := `<ul>
+<li><a href="#intro">Intro &amp; Overview</a>
+<ul>
+<li><a href="#goals">Goals</a></li>
+</ul>
+</li>
+<li>Untitled</li>
+</ul>
+`
+	out := RenderTOC(toc)
+	assert(t, out == expected, "rendered table of contents is not correct:", out)
+}
@@ -0,0 +1,126 @@
+package shimgo
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// Transport selects how the Go side communicates with a backend
+// process.
+type Transport string
+
+const (
+	// TransportTCP runs the service on a free localhost TCP port.
+	// This is the default.
+	TransportTCP Transport = "tcp"
+
+	// TransportUnix runs the service on a Unix domain socket in the
+	// server's working directory, which is only accessible to the
+	// current user. The bundled Ruby service requires puma or thin to
+	// listen on a socket.
+	TransportUnix Transport = "unix"
+
+	// TransportStdio runs the service as a worker that reads
+	// length-prefixed JSON requests on stdin and writes responses on
+	// stdout, without HTTP. Requests are multiplexed over the pipes.
+	// Each frame holds a whole document, so ConvertStream buffers
+	// its input and output with this transport.
+	TransportStdio Transport = "stdio"
+)
+
+const socketName = "service.sock"
+
+func newUnixClient(path string) *http.Client {
+	dialer := &net.Dialer{}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// setupTransport picks the address that the service listens on and
+// the client that reaches it. It must be called with exclusive access
+// to the server, after the working directory exists.
+func (s *shimServer) setupTransport() error {
+	switch s.conf.transport {
+	case TransportUnix:
+		path := filepath.Join(s.workingDirectory, socketName)
+		s.address = "unix:" + path
+		s.uri = "http://localhost"
+		s.client = newUnixClient(path)
+	case TransportStdio:
+		// the client is created when the worker starts, since it
+		// needs the worker's pipes.
+		s.address = "stdio"
+		s.uri = "http://stdio"
+		s.client = &http.Client{}
+	default:
+		port, err := findAvailablePort()
+		if err != nil {
+			return err
+		}
+
+		s.address = strconv.Itoa(port.Port)
+		s.uri = "http://localhost:" + s.address
+		s.client = http.DefaultClient
+	}
+
+	return nil
+}
+
+// attachTransport connects the client to the worker's pipes when the
+// server uses TransportStdio, and kills the worker if a request leaves
+// its input corrupt, so that it is restarted. It must be called before
+// the command starts, with exclusive access to the server.
+func (s *shimServer) attachTransport(cmd *exec.Cmd) error {
+	if s.conf.transport != TransportStdio {
+		return nil
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	conn := newStdioConn(stdin, stdout)
+	conn.abandoned = func() { killProcess(cmd) }
+	s.client = &http.Client{Transport: conn}
+
+	return nil
+}
+
+// outputDone returns a channel that is closed once the worker's output
+// has been read to the end. Wait closes the pipe that the output is
+// read from, so it must not be called before then. It must be called
+// with exclusive access to the server.
+func (s *shimServer) outputDone() <-chan struct{} {
+	if c, ok := s.client.Transport.(*stdioConn); ok {
+		return c.done
+	}
+
+	done := make(chan struct{})
+	close(done)
+
+	return done
+}
+
+// closeTransport releases the worker's pipes, if any.
+func (s *shimServer) closeTransport() {
+	if c, ok := s.client.Transport.(io.Closer); ok {
+		c.Close()
+	}
+}
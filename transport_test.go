@@ -0,0 +1,45 @@
+package shimgo
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnixTransport(t *testing.T) {
+	s := newServer(pythonServer, serverConfig{transport: TransportUnix})
+	require(t, !s.hasError(), "setting up the server should succeed", s.getError())
+
+	path := filepath.Join(s.workingDirectory, socketName)
+	assert(t, s.address == "unix:"+path, "service should listen on a socket in the working directory:", s.address)
+
+	listener, err := net.Listen("unix", path)
+	require(t, err == nil, "listening on the socket", err)
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"content": "<p>over a socket</p>"}`))
+	}))
+	defer listener.Close()
+
+	markRunning(t, s)
+	result, err := s.doConversion(context.Background(), RST, []byte("text"), Options{})
+	require(t, err == nil, "conversion over the socket should succeed", err)
+	assert(t, result.Content == "<p>over a socket</p>", "content should be decoded:", result.Content)
+}
+
+func TestTransportConfiguration(t *testing.T) {
+	c := NewConverter(WithTransport(TransportUnix), WithBackendTransport(string(rubyServer), TransportTCP))
+
+	py := c.servers.pools[pythonServer].primary()
+	assert(t, py.conf.transport == TransportUnix, "converter transport should apply to backends:", py.conf.transport)
+	assert(t, strings.HasPrefix(py.address, "unix:"), "unix backends should listen on a socket:", py.address)
+
+	rb := c.servers.pools[rubyServer].primary()
+	assert(t, rb.conf.transport == TransportTCP, "backend transport should override the converter's:", rb.conf.transport)
+	assert(t, !strings.HasPrefix(rb.address, "unix:"), "tcp backends should listen on a port:", rb.address)
+
+	cleanup(t, py)
+	cleanup(t, rb)
+}
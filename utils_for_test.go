@@ -1,8 +1,14 @@
 package shimgo
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func assert(t *testing.T, condition bool, args ...interface{}) {
@@ -24,11 +30,6 @@ func require(t *testing.T, condition bool, args ...interface{}) {
 func cleanup(t *testing.T, s *shimServer) {
 	// this is basically a re-implementation of s.stop() but with assertions if thins go wrong.
 
-	if s.workingDirectory != "" {
-		err := os.RemoveAll(s.workingDirectory)
-		require(t, err == nil, "cleanup working directory", err)
-	}
-
 	if s.pid != 0 {
 		proc, err := os.FindProcess(s.pid)
 		if err != nil {
@@ -41,3 +42,130 @@ func cleanup(t *testing.T, s *shimServer) {
 	defer s.Unlock()
 	s.setup()
 }
+
+// newFakeServer returns a server that sends its requests to the
+// handler as if its process were running, and stops it when the test
+// ends. With a nil handler, connections are refused, as they are by a
+// backend that crashed.
+func newFakeServer(t *testing.T, handler http.Handler) *shimServer {
+	backend := httptest.NewServer(handler)
+	if handler == nil {
+		backend.Close()
+	} else {
+		t.Cleanup(backend.Close)
+	}
+
+	s := newServer(pythonServer, serverConfig{})
+	s.uri = backend.URL
+	markRunning(t, s)
+
+	return s
+}
+
+// markRunning marks the server as running without starting a process,
+// and stops it when the test ends.
+func markRunning(t *testing.T, s *shimServer) {
+	s.running = true
+	t.Cleanup(func() {
+		s.running = false
+		cleanup(t, s)
+	})
+}
+
+// fakeService answers the health check and support routes, and
+// converts documents by upper-casing them. It fails to convert "fail",
+// and hangs converting "sleep". An argument after the port delays its
+// startup by that many seconds.
+const fakeService = `
+import json
+import sys
+import time
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+
+class Handler(BaseHTTPRequestHandler):
+    def log_message(self, *args):
+        pass
+
+    def respond(self, status, body):
+        data = json.dumps(body).encode("utf-8")
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(data)))
+        self.end_headers()
+        self.wfile.write(data)
+
+    def do_GET(self):
+        self.respond(200, {"status": "running"})
+
+    def do_POST(self):
+        length = int(self.headers.get("Content-Length") or 0)
+        body = self.rfile.read(length).decode("utf-8")
+        if body == "fail":
+            self.respond(500, {})
+        elif body == "sleep":
+            time.sleep(60)
+        else:
+            self.respond(200, {"content": body.upper()})
+
+
+if len(sys.argv) > 2:
+    time.sleep(float(sys.argv[2]))
+
+HTTPServer(("127.0.0.1", int(sys.argv[1])), Handler).serve_forever()
+`
+
+// registerFakeBackend registers a backend that runs fakeService for
+// the formats, skipping the test if python3 is not available.
+func registerFakeBackend(t *testing.T, name string, formats ...Format) {
+	registerSlowBackend(t, name, 0, formats...)
+}
+
+// registerSlowBackend registers a backend that runs fakeService for
+// the formats, and takes the delay to start.
+func registerSlowBackend(t *testing.T, name string, delay time.Duration, formats ...Format) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	registerTestBackend(t, name, BackendSpec{
+		Files: map[string][]byte{"fake.py": []byte(fakeService)},
+		Command: func(wd, port string) *exec.Cmd {
+			return exec.Command(python, filepath.Join(wd, "fake.py"), port, fmt.Sprint(delay.Seconds()))
+		},
+		Formats: formats,
+	})
+}
+
+// registerTestBackend registers the backend, and removes it and its
+// formats when the test ends.
+func registerTestBackend(t *testing.T, name string, spec BackendSpec) {
+	require(t, RegisterBackend(name, spec) == nil, "registering backend")
+	t.Cleanup(func() { unregisterBackend(name) })
+}
+
+func unregisterBackend(name string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	delete(registry.specs, backend(name))
+	for f, b := range registry.formats {
+		if b == backend(name) {
+			delete(registry.formats, f)
+		}
+	}
+}
+
+// cleanupConverter stops the converter's servers when the test ends,
+// checking that each one cleans up.
+func cleanupConverter(t *testing.T, c *Converter) {
+	t.Cleanup(func() {
+		for _, p := range c.servers.pools {
+			for _, s := range p.all() {
+				cleanup(t, s)
+			}
+		}
+		c.Cleanup()
+	})
+}
@@ -0,0 +1,82 @@
+package shimgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// WithPrimingDocument sets a document that Warmup renders in the
+// format, so that runtimes with a JIT or lazy loading are hot before
+// the first real conversion.
+func WithPrimingDocument(f Format, content []byte) ConverterOption {
+	return func(c *Converter) {
+		if c.priming == nil {
+			c.priming = map[Format][]byte{}
+		}
+		c.priming[f] = content
+	}
+}
+
+// Warmup starts the backends for the formats, or for all registered
+// formats if none are given, and checks that they support the
+// formats. Backends start concurrently; the formats of one backend
+// are checked in turn. The error reports every format that is not
+// available. Warmup stops waiting when the context is done, but the
+// backends that are starting keep starting.
+func (c *Converter) Warmup(ctx context.Context, formats ...Format) error {
+	if len(formats) == 0 {
+		for f := range registry.formatMap() {
+			formats = append(formats, f)
+		}
+	}
+
+	byBackend := map[backend][]Format{}
+	errs := []error{}
+	for _, f := range formats {
+		b, ok := registry.backendFor(f)
+		if !ok {
+			errs = append(errs, fmt.Errorf("warming up '%s': %w", f, ErrUnsupportedFormat))
+			continue
+		}
+		byBackend[b] = append(byBackend[b], f)
+	}
+
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for _, fs := range byBackend {
+		wg.Add(1)
+		go func(fs []Format) {
+			defer wg.Done()
+
+			for _, f := range fs {
+				if err := c.warmup(ctx, f); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("warming up '%s': %w", f, err))
+					mu.Unlock()
+				}
+			}
+		}(fs)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (c *Converter) warmup(ctx context.Context, f Format) error {
+	server, err := c.servers.getServer(ctx, f)
+	if err != nil {
+		return err
+	}
+	defer server.release()
+
+	content, ok := c.priming[f]
+	if !ok {
+		return nil
+	}
+
+	_, err = server.doConversion(ctx, f, content, Options{})
+
+	return err
+}
@@ -0,0 +1,63 @@
+package shimgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	registerFakeBackend(t, "test-warm-one", "test-warm-a", "test-warm-b")
+	registerFakeBackend(t, "test-warm-two", "test-warm-c")
+
+	c := NewConverter(WithPrimingDocument("test-warm-c", []byte("fail")))
+	cleanupConverter(t, c)
+
+	err := c.Warmup(context.Background(), "test-warm-a", "test-warm-b", "test-warm-unregistered")
+	require(t, errors.Is(err, ErrUnsupportedFormat), "unregistered formats should be reported:", err)
+
+	for _, f := range []Format{"test-warm-a", "test-warm-b"} {
+		s, ok := c.servers.lookup(f)
+		require(t, ok, "format should have a server")
+		assert(t, s.isRunning(), "warmup should start the backend for", f)
+	}
+
+	s, _ := c.servers.lookup("test-warm-c")
+	assert(t, !s.isRunning(), "warmup should only start backends for the formats")
+
+	err = c.Warmup(context.Background(), "test-warm-c")
+	var terr *TransportError
+	require(t, errors.As(err, &terr), "warmup should render the priming document:", err)
+	assert(t, terr.StatusCode == 500, "priming error should be reported:", terr)
+
+	out, err := c.Convert("test-warm-a", []byte("warm"))
+	require(t, err == nil, "conversion should succeed after warmup", err)
+	assert(t, string(out) == "WARM", "conversion should use the backend:", string(out))
+}
+
+func TestWarmupDeadline(t *testing.T) {
+	registerSlowBackend(t, "test-warm-slow", time.Second, "test-warm-slow-markup")
+
+	c := NewConverter()
+	cleanupConverter(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	err := c.Warmup(ctx, "test-warm-slow-markup")
+	assert(t, errors.Is(err, context.DeadlineExceeded), "warmup should stop at the deadline:", err)
+	assert(t, time.Since(started) < time.Second, "warmup should not wait for the backend to start:", time.Since(started))
+
+	s, _ := c.servers.lookup("test-warm-slow-markup")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !s.isRunning() {
+		time.Sleep(20 * time.Millisecond)
+	}
+	require(t, s.isRunning(), "the backend should finish starting after the deadline")
+	pid := s.getPid()
+
+	require(t, c.Warmup(context.Background(), "test-warm-slow-markup") == nil, "warmup should use the started backend")
+	assert(t, s.getPid() == pid, "the started backend should be kept")
+}